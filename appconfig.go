@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// appConfigSecrets reads the secrets to fetch from the Key Vault references in the APP_CONFIG_ENDPOINT
+// store, with the variable dotenv output writes each as. That is its key, less the fixed start of
+// APP_CONFIG_KEY_FILTER, as dotenvName maps it: with the filter MyApp:*, MyApp:Db:Password becomes DB_PASSWORD.
+func appConfigSecrets() ([]keyvaultclient.SecretRef, map[string]string) {
+	opts := keyvaultclient.AppConfigOptions{KeyFilter: appConfigKeyFilter, Label: appConfigLabel}
+	refs, err := keyvaultclient.GetAppConfigReferences(context.Background(), clientConfig(), appConfigEndpoint, opts)
+	if err != nil {
+		fatalf(exitCode(err), "Could not read the secrets from App Configuration %s: %v", appConfigEndpoint, err)
+	}
+	if len(refs) == 0 {
+		fatalf(exitConfig, "App Configuration %s holds no Key Vault references matching %q", appConfigEndpoint, appConfigKeyFilter)
+	}
+
+	prefix := appConfigKeyFilter
+	if i := strings.IndexAny(prefix, "*,"); i >= 0 {
+		prefix = prefix[:i]
+	}
+	secretRefs := make([]keyvaultclient.SecretRef, len(refs))
+	envVars := make(map[string]string, len(refs))
+	for i, ref := range refs {
+		secretRefs[i] = ref.SecretRef
+		key := strings.TrimPrefix(ref.Key, prefix)
+		if key == "" {
+			key = ref.Key
+		}
+		envVars[ref.SecretRef.Name] = dotenvName(key)
+	}
+	return secretRefs, envVars
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// fileConfig is the layout of the file given with -config. JSON is valid YAML, so either can be used.
+type fileConfig struct {
+	VaultBaseURL string         `yaml:"vaultBaseURL"`
+	Environment  string         `yaml:"environment"`
+	OutputFormat string         `yaml:"outputFormat"`
+	Auth         authConfig     `yaml:"auth"`
+	Secrets      []secretConfig `yaml:"secrets"`
+	Outputs      []outputConfig `yaml:"outputs"`
+}
+
+// authConfig holds the credentials section of a config file.
+type authConfig struct {
+	Method             string `yaml:"method"`
+	TenantID           string `yaml:"tenantID"`
+	ClientID           string `yaml:"clientID"`
+	ClientSecret       string `yaml:"clientSecret"`
+	ClientSecretFile   string `yaml:"clientSecretFile"`
+	ClientCertPath     string `yaml:"clientCertPath"`
+	ClientCertPassword string `yaml:"clientCertPassword"`
+	MSIClientID        string `yaml:"msiClientID"`
+}
+
+// secretConfig is one secret to fetch. VaultBaseURL defaults to the top level vaultBaseURL and Version to
+// the current version; TargetEnvVar is the variable dotenv output names the secret by. Decode, when set to
+// base64, decodes the value before it is written, and JSONPath then replaces a JSON value with the field
+// it names, e.g. $.password.
+type secretConfig struct {
+	Name         string `yaml:"name"`
+	Version      string `yaml:"version"`
+	VaultBaseURL string `yaml:"vaultBaseURL"`
+	TargetEnvVar string `yaml:"targetEnvVar"`
+	Decode       string `yaml:"decode"`
+	JSONPath     string `yaml:"jsonpath"`
+
+	// versionGiven records that the secret named a version, if only the current one with a trailing #,
+	// so DEFAULT_SECRET_VERSION doesn't apply to it.
+	versionGiven bool
+}
+
+// outputConfig is one of the outputs the fetched secrets are all written to: in a format (text, dotenv or
+// json) or through a template file, to a path, "-" meaning stdout.
+type outputConfig struct {
+	Format   string `yaml:"format"`
+	Template string `yaml:"template"`
+	Path     string `yaml:"path"`
+}
+
+// outputSinks checks the config's outputs and loads their templates.
+func (cfg fileConfig) outputSinks() ([]outputSink, error) {
+	var sinks []outputSink
+	paths := map[string]bool{}
+	for i, o := range cfg.Outputs {
+		if o.Path == "" {
+			return nil, fmt.Errorf("output %d has no path; use - for stdout", i)
+		}
+		if o.Path != "-" {
+			if paths[o.Path] {
+				return nil, fmt.Errorf("output %d writes to %s, as an earlier one does", i, o.Path)
+			}
+			paths[o.Path] = true
+		}
+		sink := outputSink{Format: o.Format, Path: o.Path}
+		switch {
+		case o.Template != "" && o.Format != "":
+			return nil, fmt.Errorf("output %d has both a format and a template", i)
+		case o.Template != "":
+			tmpl, err := loadTemplate(o.Template)
+			if err != nil {
+				return nil, fmt.Errorf("output %d: %v", i, err)
+			}
+			sink.Template = tmpl
+		case o.Format == "text", o.Format == "dotenv", o.Format == "json":
+		default:
+			return nil, fmt.Errorf("output %d: format %q is not one of text, dotenv, json", i, o.Format)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// loadConfigFile reads a config file. Its settings are applied as defaults for the environment variables
+// they correspond to, so flags, environment variables and .env all override the file.
+func loadConfigFile(path string) (fileConfig, error) {
+	var cfg fileConfig
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	// Strict decoding turns a misspelled key, which would otherwise be ignored, into an error giving
+	// its line.
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("Could not parse %s: %s", path, configTypeNames.Replace(err.Error()))
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name, value := range map[string]string{
+		"VAULT_BASE_URL":          cfg.VaultBaseURL,
+		"AZURE_ENVIRONMENT":       cfg.Environment,
+		"OUTPUT_FORMAT":           cfg.OutputFormat,
+		"AUTH_METHOD":             cfg.Auth.Method,
+		"AZ_TENANT_ID":            cfg.Auth.TenantID,
+		"AZ_CLIENT_ID":            cfg.Auth.ClientID,
+		"AZ_CLIENT_SECRET":        cfg.Auth.ClientSecret,
+		"AZ_CLIENT_SECRET_FILE":   cfg.Auth.ClientSecretFile,
+		"AZ_CLIENT_CERT_PATH":     cfg.Auth.ClientCertPath,
+		"AZ_CLIENT_CERT_PASSWORD": cfg.Auth.ClientCertPassword,
+		"AZ_MSI_CLIENT_ID":        cfg.Auth.MSIClientID,
+	} {
+		if _, set := lookupEnv(name); !set && value != "" {
+			os.Setenv(name, value)
+		}
+	}
+
+	for i, s := range cfg.Secrets {
+		if s.Name == "" {
+			return cfg, fmt.Errorf("%s: secret %d has no name", path, i)
+		}
+		cfg.Secrets[i].versionGiven = s.Version != "" || strings.Contains(s.Name, "#")
+		cfg.Secrets[i].Name, cfg.Secrets[i].Version, err = resolveSecretVersion("name", s.Name, s.Version)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: secret %d: %v", path, i, err)
+		}
+		if s.Decode != "" && s.Decode != "base64" {
+			return cfg, fmt.Errorf("%s: secret %d: decode %q is not base64", path, i, s.Decode)
+		}
+		if s.JSONPath != "" {
+			if _, err := parseJSONPath(s.JSONPath); err != nil {
+				return cfg, fmt.Errorf("%s: secret %d: %v", path, i, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// configTypeNames names the sections of the file in yaml's errors about them, in place of the Go types
+// they are decoded into.
+var configTypeNames = strings.NewReplacer(
+	"in type main.fileConfig", "at the top level",
+	"in type main.authConfig", "in auth",
+	"in type main.secretConfig", "in a secret",
+	"in type main.outputConfig", "in an output",
+)
+
+// validate checks the settings the file gives as parseArgs checks them, so a bad value is reported
+// against the file, even when it would be overridden. Whether the vault URLs lie in the cloud in use is
+// checked once that is known.
+func (cfg fileConfig) validate() error {
+	if cfg.Environment != "" {
+		if _, err := azure.EnvironmentFromName(cfg.Environment); err != nil {
+			return fmt.Errorf("environment %q is not one of AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud, AzureGermanCloud", cfg.Environment)
+		}
+	}
+	if cfg.VaultBaseURL != "" {
+		if _, err := parseEndpointURL("vaultBaseURL", cfg.VaultBaseURL); err != nil {
+			return err
+		}
+	}
+	switch cfg.OutputFormat {
+	case "", "text", "dotenv", "json":
+	default:
+		return fmt.Errorf("outputFormat %q is not one of text, dotenv, json", cfg.OutputFormat)
+	}
+	switch cfg.Auth.Method {
+	case "", "secret", "cert", "federated", "devicecode", "msi", "cli":
+	default:
+		return fmt.Errorf("auth method %q is not one of secret, cert, federated, devicecode, msi, cli", cfg.Auth.Method)
+	}
+	for i, s := range cfg.Secrets {
+		if s.VaultBaseURL != "" {
+			if _, err := parseEndpointURL("vaultBaseURL", s.VaultBaseURL); err != nil {
+				return fmt.Errorf("secret %d: %v", i, err)
+			}
+		}
+		if s.TargetEnvVar != "" && !envVarPattern.MatchString(s.TargetEnvVar) {
+			return fmt.Errorf("secret %d: targetEnvVar %q is not a valid variable name", i, s.TargetEnvVar)
+		}
+	}
+	return nil
+}
+
+// base64Secrets returns the names of the secrets the config says to base64-decode.
+func (cfg fileConfig) base64Secrets() map[string]bool {
+	names := map[string]bool{}
+	for _, s := range cfg.Secrets {
+		if s.Decode == "base64" {
+			names[s.Name] = true
+		}
+	}
+	return names
+}
+
+// jsonPaths returns the JSON paths the config says to extract, by secret name. loadConfigFile has
+// already checked that they parse.
+func (cfg fileConfig) jsonPaths() map[string]jsonPath {
+	paths := map[string]jsonPath{}
+	for _, s := range cfg.Secrets {
+		if s.JSONPath != "" {
+			paths[s.Name], _ = parseJSONPath(s.JSONPath)
+		}
+	}
+	return paths
+}
+
+// secretRefs returns the secrets listed in the config, read from defaultVaultURL unless they name their
+// own vault, along with the environment variable names given for dotenv output keyed by secret name.
+func (cfg fileConfig) secretRefs(defaultVaultURL string) ([]keyvaultclient.SecretRef, map[string]string) {
+	refs := make([]keyvaultclient.SecretRef, len(cfg.Secrets))
+	envVars := map[string]string{}
+	for i, s := range cfg.Secrets {
+		vaultURL := s.VaultBaseURL
+		if vaultURL == "" {
+			vaultURL = defaultVaultURL
+		}
+		refs[i] = keyvaultclient.SecretRef{VaultBaseURL: vaultURL, Name: s.Name, Version: versionOrDefault(s.Version, s.versionGiven)}
+		if s.TargetEnvVar != "" {
+			envVars[s.Name] = s.TargetEnvVar
+		}
+	}
+	return refs, envVars
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// fileContentTypes are the content types set -file records for files of known kinds, and secret save
+// writes back. binary ones are stored base64-encoded, since Key Vault only stores strings; Key Vault
+// stores the PKCS#12 archives behind certificates that way too. The first extension of each is the one
+// secret save names files with.
+var fileContentTypes = []struct {
+	contentType string
+	extensions  []string
+	binary      bool
+}{
+	{"application/x-pem-file", []string{".pem", ".crt", ".cer", ".key"}, false},
+	{"application/x-pkcs12", []string{".pfx", ".p12"}, true},
+	{"application/json", []string{".json"}, false},
+	{"application/octet-stream", []string{".bin"}, true},
+	{"text/plain", []string{".txt"}, false},
+}
+
+// mediaType returns contentType without parameters such as charset, in lower case.
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+}
+
+// binaryContentType reports whether a secret with contentType holds base64-encoded binary data.
+func binaryContentType(contentType string) bool {
+	for _, t := range fileContentTypes {
+		if t.contentType == mediaType(contentType) {
+			return t.binary
+		}
+	}
+	return false
+}
+
+// contentTypeForFile returns the content type to record for the file at path: the one its extension
+// stands for or, for an unknown extension, application/octet-stream if data isn't text and none if it is.
+func contentTypeForFile(path string, data []byte) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, t := range fileContentTypes {
+		for _, e := range t.extensions {
+			if e == ext {
+				return t.contentType
+			}
+		}
+	}
+	if !utf8.Valid(data) || strings.IndexByte(string(data), 0) >= 0 {
+		return "application/octet-stream"
+	}
+	return ""
+}
+
+// extensionFor returns the file extension for contentType, or "" when it has none.
+func extensionFor(contentType string) string {
+	for _, t := range fileContentTypes {
+		if t.contentType == mediaType(contentType) {
+			return t.extensions[0]
+		}
+	}
+	return ""
+}
+
+// secretFileValue returns the value to store for a file's contents, base64-encoding them for a binary
+// contentType.
+func secretFileValue(data []byte, contentType string) string {
+	if binaryContentType(contentType) {
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	return string(data)
+}
+
+// runSecretSave implements secret save, writing a secret's value to a file as its content type says:
+// decoded from base64 for binary types, and by default to <name> with the type's extension.
+func runSecretSave(args []string) {
+	flags := flag.NewFlagSet("secret save", flag.ExitOnError)
+	version := flags.String("version", "", "secret version; empty means the current version")
+	out := flags.String("out", "", "file to write the value to (default <name> with the content type's extension)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: secret save [-version version] [-out file] <name>")
+	}
+	name, secretVersion, err := resolveSecretVersion("name", flags.Arg(0), *version)
+	if err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+
+	client := newClient()
+
+	secret, err := client.GetSecretDetails(context.Background(), vaultBaseURL, name, secretVersion)
+	if err != nil {
+		fatalf(exitCode(err), "Could not get secret %s: %v", name, err)
+	}
+	data, err := secretFileData(secret)
+	if err != nil {
+		fatalf(exitFailure, "%v", err)
+	}
+
+	path := *out
+	if path == "" {
+		path = name + extensionFor(secret.ContentType)
+	}
+	if err := writeSecretFile(path, data); err != nil {
+		fatalf(exitFailure, "Could not write secret to %s: %v", path, err)
+	}
+	fmt.Printf("Wrote %s (%s) to %s\n", name, contentTypeOrNone(secret.ContentType), path)
+}
+
+// secretFileData returns the bytes a secret holds, decoding the value of a binary content type.
+func secretFileData(secret keyvaultclient.Secret) ([]byte, error) {
+	if !binaryContentType(secret.ContentType) {
+		return []byte(secret.Value), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(secret.Value)))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s has content type %s but is not valid base64: %v", secret.Name, secret.ContentType, err)
+	}
+	return data, nil
+}
+
+func contentTypeOrNone(contentType string) string {
+	if contentType == "" {
+		return "no content type"
+	}
+	return contentType
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// runSecretDiff implements secret diff, comparing two versions of a secret. Like diff(1) it exits 0 when
+// they are equal and 1 when they differ. Values are only printed with -show-values.
+func runSecretDiff(args []string) {
+	flags := flag.NewFlagSet("secret diff", flag.ExitOnError)
+	showValues := flags.Bool("show-values", false, "print the lines that differ, not just where they are")
+	flags.Parse(args)
+	if flags.NArg() != 3 {
+		fatalf(exitConfig, "usage: secret diff [-show-values] <name> <version1> <version2>")
+	}
+	name, v1, v2 := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	client := newClient()
+	ctx := context.Background()
+
+	a, err := client.GetSecretDetails(ctx, vaultBaseURL, name, v1)
+	if err != nil {
+		fatalf(exitCode(err), "Could not get version %s of secret %s: %v", v1, name, err)
+	}
+	b, err := client.GetSecretDetails(ctx, vaultBaseURL, name, v2)
+	if err != nil {
+		fatalf(exitCode(err), "Could not get version %s of secret %s: %v", v2, name, err)
+	}
+
+	if !writeSecretDiff(os.Stdout, a, b, *showValues) {
+		exit(exitFailure)
+	}
+}
+
+// writeSecretDiff reports whether versions a and b of a secret are equal and, for text, which lines
+// differ, as a line diff when showValues is set. It returns whether they are equal.
+func writeSecretDiff(w io.Writer, a keyvaultclient.Secret, b keyvaultclient.Secret, showValues bool) bool {
+	if a.Value == b.Value {
+		fmt.Fprintf(w, "Versions %s and %s of %s are equal\n", a.Version, b.Version, a.Name)
+		return true
+	}
+	fmt.Fprintf(w, "Versions %s and %s of %s differ\n", a.Version, b.Version, a.Name)
+	if isBinarySecret(a) || isBinarySecret(b) {
+		return false
+	}
+
+	for _, line := range diffLines(splitLines(string(a.Value)), splitLines(string(b.Value))) {
+		switch {
+		case line.op == ' ':
+		case showValues:
+			fmt.Fprintf(w, "%c %s\n", line.op, line.text)
+		case line.op == '-':
+			fmt.Fprintf(w, "- line %d of %s\n", line.number, a.Version)
+		default:
+			fmt.Fprintf(w, "+ line %d of %s\n", line.number, b.Version)
+		}
+	}
+	return false
+}
+
+// isBinarySecret reports whether a secret holds binary data, or a binary blob encoded as text, for
+// which a line diff would mean nothing.
+func isBinarySecret(s keyvaultclient.Secret) bool {
+	if binaryContentType(s.ContentType) {
+		return true
+	}
+	value := []byte(s.Value)
+	return !utf8.Valid(value) || bytes.IndexByte(value, 0) >= 0
+}
+
+func splitLines(s string) []string {
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLine is one line of a line diff: op is ' ' for a line both sides share, '-' for one only the
+// first has and '+' for one only the second has. number is the line's number on its side.
+type diffLine struct {
+	op     byte
+	number int
+	text   string
+}
+
+// diffLines returns a minimal line diff of a and b, found through their longest common subsequence.
+// Secrets are small, so the quadratic table is no concern.
+func diffLines(a []string, b []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{op: ' ', number: i + 1, text: a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{op: '-', number: i + 1, text: a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{op: '+', number: j + 1, text: b[j]})
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// runDryRun implements -dry-run. It checks the configuration as the real run would, without talking to
+// Azure, and prints the vaults, credentials and secrets it would use. It exits 2 if anything is invalid.
+func runDryRun(cfg fileConfig) {
+	refs, envVars, err := requestedSecrets(cfg)
+	if err != nil {
+		fatalf(exitConfig, "Dry run failed: %v", err)
+	}
+
+	var message string
+	for _, ref := range refs {
+		if err := validateVaultURL("vault of "+ref.Name, ref.VaultBaseURL, environment); err != nil {
+			message += fmt.Sprintln(err)
+		}
+	}
+	// The USER_ and PASSWORD_ fallback lists the password twice, but only writes one version as dotenv.
+	if writesDotenv() && (*secretsFileFlag != "" || len(cfg.Secrets) > 0) {
+		if err := checkEnvVars(refs, envVars); err != nil {
+			message += fmt.Sprintln(err)
+		}
+	}
+	if err := keyvaultclient.ValidateConfig(clientConfig()); err != nil {
+		message += fmt.Sprintf("Invalid credentials: %v\n", err)
+	}
+
+	printDryRun(os.Stdout, refs)
+	if message != "" {
+		fmt.Fprintf(os.Stderr, "Dry run failed:\n%s", message)
+		os.Exit(exitConfig)
+	}
+}
+
+// requestedSecrets returns the secrets a run without a command would fetch: the one named by -secret,
+// those listed in -secrets-file or -config, or the USER_ and PASSWORD_ secrets. The config's
+// targetEnvVar names are returned alongside, keyed by secret name. Secrets listed in App Configuration
+// can't be known without calling Azure, so none are returned for them.
+func requestedSecrets(cfg fileConfig) ([]keyvaultclient.SecretRef, map[string]string, error) {
+	switch {
+	case *secretFlag != "":
+		return []keyvaultclient.SecretRef{{VaultBaseURL: vaultBaseURL, Name: *secretFlag, Version: *secretVersionFlag}}, nil, nil
+	case *secretsFileFlag != "":
+		refs, err := readSecretRefs(*secretsFileFlag, vaultBaseURL)
+		return refs, nil, err
+	case len(cfg.Secrets) > 0:
+		refs, envVars := cfg.secretRefs(vaultBaseURL)
+		return refs, envVars, nil
+	case appConfigEndpoint != "":
+		return nil, nil, nil
+	}
+	if err := parseSecretArgs(); err != nil {
+		return nil, nil, err
+	}
+	return []keyvaultclient.SecretRef{
+		{VaultBaseURL: userVaultURL, Name: userSecretName, Version: userSecretVersion},
+		{VaultBaseURL: passwordVaultURL, Name: passwordSecretName},
+		{VaultBaseURL: passwordVaultURL, Name: passwordSecretName, Version: passwordSecretVersion},
+	}, nil, nil
+}
+
+func printDryRun(w io.Writer, refs []keyvaultclient.SecretRef) {
+	fmt.Fprintf(w, "Vault: %s (%s)\n", vaultBaseURL, environment.Name)
+	method := authMethod
+	if method == "" {
+		method = "secret"
+	}
+	fmt.Fprintf(w, "Auth method: %s\n", method)
+	if tenantID != "" || clientID != "" {
+		fmt.Fprintf(w, "Tenant: %s\nClient: %s\n", tenantID, clientID)
+	}
+	if len(outputSinks) > 0 {
+		fmt.Fprintln(w, "Outputs:")
+		for _, s := range outputSinks {
+			format := s.Format
+			if s.Template != nil {
+				format = "template " + s.Template.Name()
+			}
+			fmt.Fprintf(w, "  %s: %s\n", s, format)
+		}
+	} else {
+		fmt.Fprintf(w, "Output format: %s\n", outputFormat)
+	}
+	if len(refs) == 0 && appConfigEndpoint != "" {
+		fmt.Fprintf(w, "Secrets: the Key Vault references in %s\n", appConfigEndpoint)
+		return
+	}
+	fmt.Fprintln(w, "Secrets:")
+	for _, ref := range refs {
+		version := ref.Version
+		if version == "" {
+			version = "current"
+		}
+		fmt.Fprintf(w, "  %s (version %s) from %s\n", ref.Name, version, ref.VaultBaseURL)
+	}
+}
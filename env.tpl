@@ -2,9 +2,57 @@ AZ_SUBSCRIPTION_ID= #Your Azure subscription ID
 AZ_TENANT_ID= # Azure tenant ID
 AZ_CLIENT_ID= # Service Principal appID (from JSON response)
 AZ_CLIENT_SECRET= # Service Principal password (from JSON response)
+AZ_CLIENT_SECRET_FILE= # file holding the client secret, e.g. a Docker or Kubernetes secret mount (overrides AZ_CLIENT_SECRET)
+AZ_CLIENT_CERT_PATH= # PFX file with the Service Principal certificate, only used when AUTH_METHOD=cert
+AZ_CLIENT_CERT_PASSWORD= # password of AZ_CLIENT_CERT_PATH, if it has one
+AZURE_FEDERATED_TOKEN_FILE= # OIDC token file to exchange for an Azure AD token, only used when AUTH_METHOD=federated
+DISABLE_TOKEN_CACHE= # true to always request a fresh token and never touch the cache
+TOKEN_CACHE_DIR= # where the token is cached (default: goAzureKeyVault under the user cache dir)
+TOKEN_CACHE_KEY= # passphrase to encrypt the cached token with (default: plaintext)
+TOKEN_REFRESH_SKEW= # refresh tokens this long before they expire, e.g. 10m (default 5m)
+AZURE_ENVIRONMENT= # AzurePublicCloud (default), AzureUSGovernmentCloud, AzureChinaCloud or AzureGermanCloud
+VAULT_RESOURCE= # token resource and vault domain for a private cloud such as Azure Stack Hub, e.g. https://vault.local.azurestack.external
+AD_TOKEN_ENDPOINT= # full token URL overriding <AD endpoint>/<tenant>/oauth2/token, e.g. https://adfs.local.azurestack.external/adfs/oauth2/token
+CA_BUNDLE= # PEM file of extra CA certificates to trust, e.g. for a TLS-inspecting proxy (HTTPS_PROXY and NO_PROXY are honored too)
+INSECURE_SKIP_VERIFY= # true skips TLS certificate verification, for a local emulator with a self-signed certificate only; requires VAULT_RESOURCE
+DIAL_TIMEOUT= # how long connecting to Key Vault, Azure AD or the proxy may take, e.g. 5s (default 10s)
+TLS_HANDSHAKE_TIMEOUT= # how long the TLS handshake may take once connected (default 10s)
+IDLE_CONN_TIMEOUT= # how long idle connections are kept open for reuse (default 90s)
+AUTH_METHOD= # secret (default), cert, federated, devicecode, msi or cli
+AZ_MSI_CLIENT_ID= # client id of a user-assigned identity, only used when AUTH_METHOD=msi
 VAULT_BASE_URL=https://gokeyvaulttest1.vault.azure.net #(from JSON response)
-USER_SECRET_NAME=UserName
+USER_SECRET_NAME=UserName # or "UserName#<version>" (quoted) in place of USER_SECRET_VERSION
 USER_SECRET_VERSION= # (from JSON response)
+USER_SECRET_VAULT_URL= # vault to read USER_SECRET_NAME from (default: VAULT_BASE_URL)
 PASSWORD_SECRET_NAME=Password
 PASSWORD_SECRET_VERSION= # (from JSON response)
+PASSWORD_SECRET_VAULT_URL= # vault to read PASSWORD_SECRET_NAME from (default: VAULT_BASE_URL)
+DEFAULT_SECRET_VERSION= # version of every secret that names none itself; only for versions shared across secrets
+MAX_CONCURRENCY= # concurrent requests when fetching many secrets (default 8)
+REQUEST_TIMEOUT= # upper bound on each Key Vault request, e.g. 30s (default none)
+OPERATION_DEADLINE= # upper bound on each operation including all its retries and waits, e.g. 2m (default none)
+MAX_RETRIES= # retries for throttled (429) or transiently failing requests (default 3, 0 disables)
+REQUESTS_PER_SECOND= # cap on requests per second to Key Vault, e.g. 20 (default: no limit)
+CIRCUIT_BREAKER_THRESHOLD= # throttled responses in a row that open the circuit breaker for a vault, e.g. 5 (default: 0, off)
+CIRCUIT_BREAKER_WINDOW= # window the throttled responses must fall in, e.g. 2m (default: 1m)
+CIRCUIT_BREAKER_COOLDOWN= # how long requests to a vault fail fast once the breaker opens, e.g. 1m (default: 30s, or Retry-After if longer)
+SECRET_CACHE_TTL= # serve secrets from memory this long before fetching them again, e.g. 5m (default 0: no cache)
+STRICT_EXPIRY= # true to fail, rather than warn, when a secret is read after it expires or before it is active
+STRICT_MODE= # true to write nothing, rather than the secrets that could be read, when any secret is missing
+AUDIT_LOG_PATH= # file every secret read is appended to as a JSON line, without the value (default: no audit log)
+OUTPUT_FORMAT= # text (default), dotenv or json
+OUTPUT_FILE_MODE= # octal permissions of the files written, e.g. 0640 (default 0600)
+QUIET= # true to print only the secret values, without banners and labels
+LOG_OUTPUT= # stderr (default) or stdout
+LOG_FORMAT= # json (default) or text, colored on a terminal
+METRICS_ADDR= # e.g. :9090 to serve Prometheus metrics on /metrics (default: off)
+OTEL_EXPORTER_OTLP_ENDPOINT= # e.g. http://localhost:4318 to export traces over OTLP/HTTP (default: off)
+POLL_INTERVAL= # how often -watch checks for new secret versions, in seconds or e.g. 5m (default 60)
+RELOAD_COMMAND= # command -watch runs after rewriting -out, e.g. systemctl reload myapp
+HEALTHCHECK_SECRET= # secret the healthcheck command reads; when empty it lists one secret instead
+APP_CONFIG_ENDPOINT= # optional, e.g. https://myconfig.azconfig.io: read the secrets to fetch from its Key Vault references
+APP_CONFIG_KEY_FILTER= # optional, e.g. MyApp:* to read only those keys
+APP_CONFIG_LABEL= # optional label of the key-values to read (default: no label)
+PFX_PASSWORD= # password cert get -pfx encrypts the .pfx file with (default none)
+ENV_PREFIX= # e.g. APP1: read each setting from APP1_<name> first, falling back to <name>
 LOG_LEVEL=INFO=WARN
\ No newline at end of file
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// Exit codes, so scripts and CI can tell why a run failed. Bad flags exit with exitConfig too, as the
+// flag package exits with 2.
+const (
+	exitFailure  = 1 // anything not covered below
+	exitConfig   = 2 // missing or invalid settings, flags or arguments
+	exitAuth     = 3 // sign in failed, or access to the vault or secret was denied
+	exitNotFound = 4 // a requested secret does not exist
+	exitNetwork  = 5 // the vault or Azure AD couldn't be reached, or requests timed out or were throttled
+)
+
+// exitCode returns the exit code for an error returned by keyvaultclient.
+func exitCode(err error) int {
+	var de autorest.DetailedError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, keyvaultclient.ErrAuthentication), errors.Is(err, keyvaultclient.ErrAccessDenied):
+		return exitAuth
+	case errors.Is(err, keyvaultclient.ErrSecretNotFound):
+		return exitNotFound
+	case errors.Is(err, keyvaultclient.ErrManagedHSMUnsupported):
+		return exitConfig
+	case errors.Is(err, keyvaultclient.ErrThrottled), errors.Is(err, context.DeadlineExceeded):
+		return exitNetwork
+	case errors.As(err, &de) && errors.As(de.Original, &netErr):
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// fatalf logs at error level, as log.Fatalf does, and exits with code.
+func fatalf(code int, format string, args ...interface{}) {
+	log.Errorf(format, args...)
+	exit(code)
+}
+
+// exit exits with code, printing the -timings summary first, as deferred calls don't run.
+func exit(code int) {
+	if *timingsFlag {
+		printTimings()
+	}
+	os.Exit(code)
+}
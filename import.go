@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/subosito/gotenv"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// runImport implements the import subcommand, uploading every KEY=VALUE pair of a .env file as a
+// secret named <prefix><KEY>, reporting whether each was created or updated.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "prefix prepended to every secret name, e.g. myapp-")
+	dryRun := flags.Bool("dry-run", false, "print the secrets that would be uploaded without writing them")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: import [-prefix prefix] [-dry-run] <file>")
+	}
+	path := flags.Arg(0)
+
+	values, err := readImportFile(path, *prefix)
+	if err != nil {
+		fatalf(exitConfig, "Could not read %s: %v", path, err)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if *dryRun {
+		for _, name := range names {
+			fmt.Printf("Would upload %s\n", name)
+		}
+		return
+	}
+
+	client := newClient()
+	ctx := context.Background()
+
+	ids, err := client.ListSecretsFiltered(ctx, vaultBaseURL, keyvaultclient.ListOptions{IncludeDisabled: true})
+	if err != nil {
+		fatalf(exitCode(err), "Could not list secrets in %s: %v", vaultBaseURL, err)
+	}
+	existing := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		existing[strings.ToLower(keyvaultclient.SecretNameFromID(id))] = true
+	}
+
+	code := 0
+	for _, name := range names {
+		version, err := client.SetSecret(ctx, vaultBaseURL, name, values[name], keyvaultclient.SecretOptions{})
+		if err != nil {
+			log.Warnf("Could not set secret %s: %v", name, err)
+			if code == 0 {
+				code = exitCode(err)
+			}
+			continue
+		}
+		action := "Created"
+		if existing[strings.ToLower(name)] {
+			action = "Updated"
+		}
+		fmt.Printf("%s %s version %s\n", action, name, version)
+	}
+	if code != 0 {
+		exit(code)
+	}
+}
+
+// readImportFile parses a .env file, returning the values keyed by the secret names they are uploaded
+// as. Key Vault names may only hold letters, digits and dashes, so the _ and . allowed in variable names
+// become dashes; two variables that end up with the same name are an error.
+func readImportFile(path string, prefix string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env, err := gotenv.StrictParse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make(map[string]string, len(env))
+	vars := make(map[string]string, len(env))
+	for _, key := range keys {
+		name := prefix + strings.NewReplacer("_", "-", ".", "-").Replace(key)
+		folded := strings.ToLower(name)
+		if other, ok := vars[folded]; ok {
+			return nil, fmt.Errorf("%s and %s would both be uploaded as %s", other, key, name)
+		}
+		vars[folded] = key
+		values[name] = env[key]
+	}
+	return values, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPaths are the JSON paths the config file says to extract from secrets, keyed by secret name.
+var jsonPaths map[string]jsonPath
+
+// jsonPath is a parsed JSON path such as $.password or $.servers[0]["host name"]: the object keys and
+// array indexes leading from the document's root to one value.
+type jsonPath struct {
+	expr  string
+	steps []jsonPathStep
+}
+
+// jsonPathStep is an object key or, when isIndex is set, an array index.
+type jsonPathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseJSONPath parses the subset of JSONPath that names a single value: $ followed by .key, ["key"]
+// (or ['key']) and [index] steps. A quoted key can't itself contain ].
+func parseJSONPath(expr string) (jsonPath, error) {
+	p := jsonPath{expr: expr}
+	if !strings.HasPrefix(expr, "$") {
+		return p, fmt.Errorf("jsonpath %q doesn't start with $", expr)
+	}
+	rest := expr[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return p, fmt.Errorf("jsonpath %q has an empty key", expr)
+			}
+			p.steps = append(p.steps, jsonPathStep{key: key})
+			rest = rest[end+1:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return p, fmt.Errorf("jsonpath %q has an unclosed [", expr)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				p.steps = append(p.steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			} else if index, err := strconv.Atoi(inner); err == nil && index >= 0 {
+				p.steps = append(p.steps, jsonPathStep{index: index, isIndex: true})
+			} else {
+				return p, fmt.Errorf("jsonpath %q: [%s] is neither a quoted key nor an array index", expr, inner)
+			}
+			rest = rest[end+1:]
+		default:
+			return p, fmt.Errorf("jsonpath %q: unexpected %q", expr, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// extract parses value as JSON and returns what the path points to: a string as it is, anything else,
+// such as a number or a nested object, as JSON.
+func (p jsonPath) extract(value string) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return "", fmt.Errorf("is not valid JSON: %v", err)
+	}
+
+	at := "$"
+	for _, step := range p.steps {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			if step.isIndex {
+				return "", fmt.Errorf("has an object at %s, not an array", at)
+			}
+			child, ok := node[step.key]
+			if !ok {
+				return "", fmt.Errorf("has no %q at %s", step.key, at)
+			}
+			doc = child
+		case []interface{}:
+			if !step.isIndex {
+				return "", fmt.Errorf("has an array at %s, not an object", at)
+			}
+			if step.index >= len(node) {
+				return "", fmt.Errorf("has no element %d in the %d element array at %s", step.index, len(node), at)
+			}
+			doc = node[step.index]
+		default:
+			return "", fmt.Errorf("has no object or array at %s", at)
+		}
+		if step.isIndex {
+			at += fmt.Sprintf("[%d]", step.index)
+		} else {
+			at += "." + step.key
+		}
+	}
+
+	if s, ok := doc.(string); ok {
+		return s, nil
+	}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
@@ -0,0 +1,148 @@
+package keyvaultclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// appConfigKeyVaultRefContentType marks an App Configuration key-value whose value points at a Key Vault secret.
+const appConfigKeyVaultRefContentType = "application/vnd.microsoft.appconfig.keyvaultref+json"
+
+// AppConfigOptions selects the key-values GetAppConfigReferences reads.
+type AppConfigOptions struct {
+	// KeyFilter limits the keys read, using App Configuration's filter syntax: MyApp:* reads every key
+	// starting with MyApp:. Empty reads every key.
+	KeyFilter string
+	// Label reads the key-values with this label. Empty reads those without one.
+	Label string
+}
+
+// AppConfigReference is a Key Vault reference stored in Azure App Configuration: the key-value's key,
+// and the secret its value points at.
+type AppConfigReference struct {
+	Key       string
+	SecretRef SecretRef
+}
+
+// appConfigPage is a page of key-values as App Configuration's REST API returns it.
+type appConfigPage struct {
+	Items []struct {
+		Key         string `json:"key"`
+		ContentType string `json:"content_type"`
+		Value       string `json:"value"`
+	} `json:"items"`
+	NextLink string `json:"@nextLink"`
+}
+
+// GetAppConfigReferences reads the Key Vault references in the App Configuration store at endpoint, such as
+// https://myconfig.azconfig.io, authenticating with cfg's credentials. Key-values that aren't Key Vault
+// references are skipped. The identity needs the App Configuration Data Reader role on the store.
+func GetAppConfigReferences(ctx context.Context, cfg Config, endpoint string, opts AppConfigOptions) ([]AppConfigReference, error) {
+	defer timeTrack(time.Now(), "getAppConfigReferences")
+	base, err := url.Parse(endpoint)
+	if err != nil || base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("App Configuration endpoint %q is not an https URL", endpoint)
+	}
+	// App Configuration accepts tokens issued for the store's own endpoint.
+	authorizer, err := sharedAuthorizer(ctx, cfg, "https://"+base.Host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+
+	query := url.Values{"api-version": {"1.0"}, "label": {"\x00"}}
+	if opts.KeyFilter != "" {
+		query.Set("key", opts.KeyFilter)
+	}
+	if opts.Label != "" {
+		query.Set("label", opts.Label)
+	}
+	link := (&url.URL{Scheme: "https", Host: base.Host, Path: "/kv", RawQuery: query.Encode()}).String()
+
+	var refs []AppConfigReference
+	for link != "" {
+		page, err := getAppConfigPage(ctx, cfg, authorizer, link)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if !strings.HasPrefix(item.ContentType, appConfigKeyVaultRefContentType) {
+				log.Debugf("Skipping App Configuration key that isn't a Key Vault reference. key=%q", item.Key)
+				continue
+			}
+			ref, err := parseAppConfigReference(item.Value)
+			if err != nil {
+				return nil, fmt.Errorf("App Configuration key %s: %v", item.Key, err)
+			}
+			refs = append(refs, AppConfigReference{Key: item.Key, SecretRef: ref})
+		}
+		link = ""
+		if page.NextLink != "" {
+			next, err := base.Parse(page.NextLink)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse the next page link %q: %v", page.NextLink, err)
+			}
+			link = next.String()
+		}
+	}
+	return refs, nil
+}
+
+// getAppConfigPage requests one page of key-values.
+func getAppConfigPage(ctx context.Context, cfg Config, authorizer autorest.Authorizer, link string) (appConfigPage, error) {
+	var page appConfigPage
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return page, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.microsoft.appconfig.kvset+json, application/json")
+	req, err = autorest.Prepare(req, authorizer.WithAuthorization())
+	if err != nil {
+		return page, fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+
+	resp, err := httpClient(cfg).Do(req)
+	if err != nil {
+		return page, fmt.Errorf("Could not read App Configuration: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return page, fmt.Errorf("Could not read App Configuration: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return page, fmt.Errorf("%w: App Configuration answered %s", ErrAuthentication, resp.Status)
+	case http.StatusForbidden:
+		return page, fmt.Errorf("%w: App Configuration answered %s", ErrAccessDenied, resp.Status)
+	default:
+		return page, fmt.Errorf("App Configuration answered %s: %s", resp.Status, body)
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return page, fmt.Errorf("Could not parse the App Configuration response: %v", err)
+	}
+	return page, nil
+}
+
+// parseAppConfigReference reads the value of a Key Vault reference, {"uri":"https://myvault.vault.azure.net/secrets/Name"},
+// optionally with the version after the name.
+func parseAppConfigReference(value string) (SecretRef, error) {
+	var reference struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal([]byte(value), &reference); err != nil {
+		return SecretRef{}, fmt.Errorf("not a Key Vault reference: %v", err)
+	}
+	return secretRefFromURI(reference.URI)
+}
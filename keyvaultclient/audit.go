@@ -0,0 +1,56 @@
+package keyvaultclient
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// auditLogger writes a JSON line to Config.AuditLog for every secret read. It is separate from the
+// package's log output, so the trail can't be silenced by LOG_LEVEL or mixed with diagnostics. A nil
+// *auditLogger records nothing.
+type auditLogger struct {
+	logger     *log.Logger
+	authMethod string
+	clientID   string
+}
+
+func newAuditLogger(cfg Config) *auditLogger {
+	if cfg.AuditLog == nil {
+		return nil
+	}
+	logger := log.New()
+	logger.Out = cfg.AuditLog
+	logger.Formatter = &log.JSONFormatter{}
+	logger.Level = log.InfoLevel
+
+	a := &auditLogger{logger: logger, authMethod: cfg.AuthMethod, clientID: cfg.ClientID}
+	switch cfg.AuthMethod {
+	case "":
+		a.authMethod = "secret"
+	case "msi":
+		a.clientID = cfg.MSIClientID
+	case "devicecode":
+		a.clientID = deviceCodeConfig(cfg).ClientID
+	}
+	return a
+}
+
+// secretRead records a read of ref, which resolved to version. The value is never recorded.
+func (a *auditLogger) secretRead(ref SecretRef, version string, cached bool, err error) {
+	if a == nil {
+		return
+	}
+	entry := a.logger.WithFields(log.Fields{
+		"authMethod":       a.authMethod,
+		"clientID":         a.clientID,
+		"vault":            ref.VaultBaseURL,
+		"secret":           ref.Name,
+		"requestedVersion": ref.Version,
+		"version":          version,
+		"cached":           cached,
+		"success":          err == nil,
+	})
+	if err != nil {
+		entry = entry.WithField("error", err.Error())
+	}
+	entry.Info("Secret read")
+}
@@ -0,0 +1,476 @@
+package keyvaultclient
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// vaultAuthorizer authorizes each request with a token for the vault it is sent to. Authorizers are
+// kept per vault host, and hosts that need a token for the same resource share one, so a Client can
+// read from any number of vaults in its cloud while authenticating only once.
+type vaultAuthorizer struct {
+	cfg Config
+
+	mu         sync.Mutex
+	byHost     map[string]autorest.Authorizer
+	byResource map[string]autorest.Authorizer
+}
+
+// newVaultAuthorizer authenticates for the Key Vault resource of the configured cloud up front, so
+// bad credentials are reported by New rather than by the first request.
+func newVaultAuthorizer(ctx context.Context, cfg Config) (*vaultAuthorizer, error) {
+	resource := vaultResource(environment(cfg))
+	authorizer, err := sharedAuthorizer(ctx, cfg, resource)
+	if err != nil {
+		return nil, err
+	}
+	return &vaultAuthorizer{
+		cfg:        cfg,
+		byHost:     map[string]autorest.Authorizer{},
+		byResource: map[string]autorest.Authorizer{resource: authorizer},
+	}, nil
+}
+
+// WithAuthorization implements autorest.Authorizer. Requests a Managed HSM pool can't serve are refused
+// before a token is requested for them.
+func (a *vaultAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			if err := checkManagedHSMRequest(environment(a.cfg), r); err != nil {
+				return r, err
+			}
+			authorizer, err := a.forHost(r.Context(), r.URL.Hostname())
+			if err != nil {
+				return r, err
+			}
+			return authorizer.WithAuthorization()(p).Prepare(r)
+		})
+	}
+}
+
+// forHost returns the authorizer for host. a.mu isn't held while a new one authenticates, so requests to
+// hosts that already have one aren't held up; if two goroutines race, the first one stored is kept.
+func (a *vaultAuthorizer) forHost(ctx context.Context, host string) (autorest.Authorizer, error) {
+	a.mu.Lock()
+	if authorizer, ok := a.byHost[host]; ok {
+		a.mu.Unlock()
+		return authorizer, nil
+	}
+	resource, err := resourceForHost(environment(a.cfg), host)
+	if err != nil {
+		a.mu.Unlock()
+		return nil, err
+	}
+	authorizer, ok := a.byResource[resource]
+	a.mu.Unlock()
+
+	if !ok {
+		authorizer, err = sharedAuthorizer(ctx, a.cfg, resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if existing, ok := a.byResource[resource]; ok {
+		authorizer = existing
+	} else {
+		a.byResource[resource] = authorizer
+	}
+	a.byHost[host] = authorizer
+	return authorizer, nil
+}
+
+// TokenExpiresOn returns when the token the Client sends to Key Vault expires. It is read afresh on each
+// call, so once the token has been refreshed, which happens on the first request within
+// Config.TokenRefreshSkew of this time, it reports the new token's expiry. To renew ahead of that, call
+// again no earlier than the returned time minus the skew. A Client from NewWithSecretGetter has no token,
+// and the expiry of one from a TokenProvider other than ConfigTokenProvider is unknown.
+func (c *Client) TokenExpiresOn() (time.Time, error) {
+	if c.authorizer == nil {
+		return time.Time{}, errors.New("the expiry of the client's token is unknown")
+	}
+	return c.authorizer.expiry()
+}
+
+// expiry returns the expiry of the token for the Key Vault resource of the configured cloud.
+func (a *vaultAuthorizer) expiry() (time.Time, error) {
+	a.mu.Lock()
+	authorizer := a.byResource[vaultResource(environment(a.cfg))]
+	a.mu.Unlock()
+	ta, ok := authorizer.(*tokenAuthorizer)
+	if !ok {
+		return time.Time{}, fmt.Errorf("the expiry of a %T token is unknown", authorizer)
+	}
+	return ta.provider.expiry(), nil
+}
+
+// resourceForHost returns the resource to request tokens for when talking to a vault or Managed HSM
+// host. Tokens are never sent to hosts outside the cloud's Key Vault and Managed HSM domains.
+func resourceForHost(env azure.Environment, host string) (string, error) {
+	if isManagedHSMHost(env, host) {
+		return "https://" + ManagedHSMDNSSuffix(env), nil
+	}
+	if !strings.HasSuffix(host, "."+env.KeyVaultDNSSuffix) {
+		return "", fmt.Errorf("vault %s is not in %s (*.%s)", host, env.Name, env.KeyVaultDNSSuffix)
+	}
+	return vaultResource(env), nil
+}
+
+// authorizers holds the authorizer created for each set of credentials and resource, so a process that
+// creates many Clients authenticates and reads the token cache once. The tokens behind them refresh
+// themselves as they near expiry.
+var authorizers = struct {
+	sync.Mutex
+	m map[authorizerKey]*authorizerEntry
+}{m: map[authorizerKey]*authorizerEntry{}}
+
+// authorizerEntry is an authorizer in authorizers, which is being created until done is closed.
+type authorizerEntry struct {
+	done       chan struct{}
+	authorizer autorest.Authorizer
+	err        error
+}
+
+type authorizerKey struct {
+	authMethod, tenantID, clientID, clientSecret, msiClientID string
+	clientCertPath, clientCertPassword, federatedTokenFile    string
+	activeDirectoryEndpoint, tokenEndpoint, resource          string
+	disableTokenCache                                         bool
+	tokenCacheDir, tokenCacheKey                              string
+	tokenRefreshSkew                                          time.Duration
+	maxRetries                                                int
+	httpClient                                                *http.Client
+}
+
+// sharedAuthorizer returns the authorizer already created for cfg and resource, or creates one, as it
+// always does with Config.ForceTokenRefresh. Authenticating happens outside the authorizers lock, which
+// can take minutes with devicecode, so only callers for the same key wait for it. Failures aren't
+// remembered: a caller that waited for one tries again itself.
+func sharedAuthorizer(ctx context.Context, cfg Config, resource string) (autorest.Authorizer, error) {
+	key := authorizerKey{
+		authMethod:              cfg.AuthMethod,
+		tenantID:                cfg.TenantID,
+		clientID:                cfg.ClientID,
+		clientSecret:            cfg.ClientSecret,
+		msiClientID:             cfg.MSIClientID,
+		clientCertPath:          cfg.ClientCertPath,
+		clientCertPassword:      cfg.ClientCertPassword,
+		federatedTokenFile:      cfg.FederatedTokenFile,
+		activeDirectoryEndpoint: environment(cfg).ActiveDirectoryEndpoint,
+		tokenEndpoint:           cfg.TokenEndpoint,
+		resource:                resource,
+		disableTokenCache:       cfg.DisableTokenCache,
+		tokenCacheDir:           cfg.TokenCacheDir,
+		tokenCacheKey:           cfg.TokenCacheKey,
+		tokenRefreshSkew:        tokenRefreshSkew(cfg),
+		maxRetries:              maxRetries(cfg),
+		httpClient:              cfg.HTTPClient,
+	}
+
+	for {
+		authorizers.Lock()
+		entry, ok := authorizers.m[key]
+		if !ok || cfg.ForceTokenRefresh {
+			entry = &authorizerEntry{done: make(chan struct{})}
+			authorizers.m[key] = entry
+			authorizers.Unlock()
+
+			entry.authorizer, entry.err = getKeyvaultAuthorizer(ctx, cfg, resource)
+			if entry.err != nil {
+				authorizers.Lock()
+				if authorizers.m[key] == entry {
+					delete(authorizers.m, key)
+				}
+				authorizers.Unlock()
+			}
+			close(entry.done)
+			return entry.authorizer, entry.err
+		}
+		authorizers.Unlock()
+
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if entry.err == nil {
+			return entry.authorizer, nil
+		}
+	}
+}
+
+// ValidateConfig checks cfg as far as is possible without contacting Azure: the auth method is known, the
+// Service Principal settings it needs are present and form a valid OAuth configuration, and a client
+// certificate or federated token file can be read. It doesn't check that the credentials are accepted.
+func ValidateConfig(cfg Config) error {
+	switch cfg.AuthMethod {
+	case "msi", "cli", "devicecode":
+		return nil
+	case "", "secret", "cert", "federated":
+	default:
+		return fmt.Errorf("unknown auth method %q", cfg.AuthMethod)
+	}
+
+	if cfg.TenantID == "" || cfg.ClientID == "" {
+		return errors.New("the tenant and client ID are required")
+	}
+	if _, err := tenantOAuthConfig(environment(cfg).ActiveDirectoryEndpoint, cfg.TenantID); err != nil {
+		return err
+	}
+	if cfg.TokenEndpoint != "" {
+		if _, err := url.Parse(cfg.TokenEndpoint); err != nil {
+			return fmt.Errorf("Could not parse the token endpoint URL: %v", err)
+		}
+	}
+
+	switch cfg.AuthMethod {
+	case "cert":
+		_, _, err := loadClientCertificate(cfg.ClientCertPath, cfg.ClientCertPassword)
+		return err
+	case "federated":
+		_, err := readFederatedToken(cfg.FederatedTokenFile)
+		return err
+	}
+	if cfg.ClientSecret == "" {
+		return errors.New("the client secret is required")
+	}
+	return nil
+}
+
+// oauthConfigs holds the OAuth configuration built for each Azure AD endpoint and tenant, so the URLs in
+// it are parsed once however many authorizers are created.
+var oauthConfigs = struct {
+	sync.Mutex
+	m map[[2]string]*adal.OAuthConfig
+}{m: map[[2]string]*adal.OAuthConfig{}}
+
+// tenantOAuthConfig returns a copy of the OAuth configuration for tenantID at activeDirectoryEndpoint,
+// building it on first use. The copy is the caller's to change, as for a token endpoint override.
+func tenantOAuthConfig(activeDirectoryEndpoint string, tenantID string) (*adal.OAuthConfig, error) {
+	key := [2]string{activeDirectoryEndpoint, tenantID}
+	oauthConfigs.Lock()
+	defer oauthConfigs.Unlock()
+	if oauthConfig, ok := oauthConfigs.m[key]; ok {
+		copied := *oauthConfig
+		return &copied, nil
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(activeDirectoryEndpoint, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create oauthConfig: %v", err.Error())
+	}
+	updatedAuthorizeEndpoint, err := url.Parse(strings.TrimSuffix(activeDirectoryEndpoint, "/") + "/" + tenantID + "/oauth2/token")
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse the Authorize Endpoint URL: %v", err.Error())
+	}
+	oauthConfig.AuthorizeEndpoint = *updatedAuthorizeEndpoint
+
+	oauthConfigs.m[key] = oauthConfig
+	copied := *oauthConfig
+	return &copied, nil
+}
+
+// getKeyvaultAuthorizer authenticates as configured, requesting tokens for resource.
+func getKeyvaultAuthorizer(ctx context.Context, cfg Config, resource string) (authorizer autorest.Authorizer, err error) {
+	_, span := tracer.Start(ctx, "getKeyvaultAuthorizer", trace.WithAttributes(
+		attribute.String("auth.method", cfg.AuthMethod),
+		attribute.String("auth.resource", resource),
+	))
+	defer func() { endSpan(span, err) }()
+
+	skew := tokenRefreshSkew(cfg)
+	if cfg.AuthMethod == "msi" {
+		return getMSIAuthorizer(resource, cfg.MSIClientID, skew, httpClient(cfg))
+	}
+
+	env := environment(cfg)
+
+	if cfg.AuthMethod == "devicecode" {
+		cfg = deviceCodeConfig(cfg)
+	}
+	if cfg.AuthMethod == "cli" {
+		azPath, err := exec.LookPath("az")
+		if err == nil {
+			return getAzureCLIAuthorizer(azPath, resource, skew)
+		}
+		log.Warnf("Azure CLI not found, falling back to the service principal: %v", err)
+	}
+
+	oauthConfig, err := tenantOAuthConfig(env.ActiveDirectoryEndpoint, cfg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TokenEndpoint != "" {
+		tokenEndpoint, err := url.Parse(cfg.TokenEndpoint)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse the token endpoint URL: %v", err)
+		}
+		oauthConfig.TokenEndpoint = *tokenEndpoint
+	}
+
+	var rawToken *adal.Token
+	cacheName := cfg.ClientID
+	if resource != vaultResource(env) {
+		// Tokens for other services, such as App Configuration, are cached apart from the Key Vault one.
+		cacheName += "." + url.PathEscape(strings.TrimPrefix(resource, "https://"))
+	}
+	cachePath := filepath.Join(tokenCacheDir(cfg), fmt.Sprintf("%s.token.json", cacheName))
+	if !cfg.DisableTokenCache && !cfg.ForceTokenRefresh {
+		rawToken, err = tryLoadCachedToken(cachePath, cfg.TokenCacheKey)
+		if err != nil {
+			rawToken = nil
+			log.Warnf("Could not load Raw Token from file: %v", err)
+		}
+		observeTokenCacheLookup(rawToken, skew)
+	}
+
+	// Every token the Service Principal obtains, including automatic refreshes near expiry, is written
+	// back to the cache.
+	saveToken := func(token adal.Token) error {
+		if cfg.DisableTokenCache {
+			return nil
+		}
+		if err := saveCachedToken(cachePath, cfg.TokenCacheKey, token); err != nil {
+			log.Warnf("Could not save token to cache path=%q: %v", cachePath, err.Error())
+			return nil
+		}
+		log.Debugf("Saved token to cache. path=%q", cachePath)
+		return nil
+	}
+	if cfg.AuthMethod == "devicecode" {
+		return getDeviceCodeAuthorizer(*oauthConfig, cfg, resource, rawToken, saveToken)
+	}
+
+	newSPT := func() (*adal.ServicePrincipalToken, error) {
+		return adal.NewServicePrincipalToken(*oauthConfig, cfg.ClientID, cfg.ClientSecret, resource, saveToken)
+	}
+	switch cfg.AuthMethod {
+	case "cert":
+		certificate, key, err := loadClientCertificate(cfg.ClientCertPath, cfg.ClientCertPassword)
+		if err != nil {
+			return nil, err
+		}
+		newSPT = func() (*adal.ServicePrincipalToken, error) {
+			return adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, cfg.ClientID, certificate, key, resource, saveToken)
+		}
+	case "federated":
+		if _, err := readFederatedToken(cfg.FederatedTokenFile); err != nil {
+			return nil, err
+		}
+		newSPT = func() (*adal.ServicePrincipalToken, error) {
+			secret := &federatedTokenSecret{path: cfg.FederatedTokenFile}
+			return adal.NewServicePrincipalTokenWithSecret(*oauthConfig, cfg.ClientID, resource, secret, saveToken)
+		}
+	}
+	newToken := func() (*adal.ServicePrincipalToken, error) {
+		defer timeTrack(time.Now(), "NewServicePrincipalToken")
+		spt, err := newSPT()
+		if err != nil {
+			return nil, err
+		}
+		spt.SetSender(tracingSender{sender: httpClient(cfg)})
+		spt.SetRefreshWithin(skew)
+		return spt, nil
+	}
+
+	// A cached token that expires within the skew is no better than an expired one: it would run out
+	// during the first requests.
+	if rawToken != nil && !rawToken.WillExpireIn(skew) {
+		defer timeTrack(time.Now(), "NewServicePrincipalTokenFromManualToken")
+		spt, err := adal.NewServicePrincipalTokenFromManualToken(*oauthConfig, cfg.ClientID, resource, *rawToken)
+		if err != nil {
+			return nil, err
+		}
+		spt.SetSender(tracingSender{sender: httpClient(cfg)})
+		return newTokenAuthorizer(&cachedTokenRefresher{token: spt, refreshWithin: skew, maxRetries: maxRetries(cfg), newToken: newToken}), nil
+	}
+
+	spt, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	// Carrying on without a token would only turn this failure into a confusing 401 from Key Vault.
+	if err := refreshWithRetry(spt.Refresh, maxRetries(cfg)); err != nil {
+		return nil, refreshError(err)
+	}
+
+	authorizer = newTokenAuthorizer(newSyncRefresher(spt, skew))
+	return authorizer, nil
+}
+
+// getMSIAuthorizer authenticates with the managed identity of the Azure VM or App Service we are running on.
+// A user-assigned identity is used when msiClientID is set, otherwise the system-assigned one.
+func getMSIAuthorizer(resource string, msiClientID string, refreshSkew time.Duration, client *http.Client) (autorest.Authorizer, error) {
+	defer timeTrack(time.Now(), "getMSIAuthorizer")
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get the MSI endpoint: %v", err)
+	}
+
+	var spt *adal.ServicePrincipalToken
+	if msiClientID != "" {
+		spt, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, resource, msiClientID)
+	} else {
+		spt, err = adal.NewServicePrincipalTokenFromMSI(msiEndpoint, resource)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not create MSI token: %v", err)
+	}
+	spt.SetSender(tracingSender{sender: client})
+	spt.SetRefreshWithin(refreshSkew)
+	return newTokenAuthorizer(newSyncRefresher(spt, refreshSkew)), nil
+}
+
+// loadClientCertificate reads the Service Principal's certificate and private key from a PKCS#12 file.
+// Azure AD signs in certificate credentials with RS256, so the key has to be RSA.
+func loadClientCertificate(path string, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read the client certificate: %v", err)
+	}
+	key, certificate, err := pkcs12.Decode(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not decode the client certificate %s: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("Could not use the client certificate %s: the private key is %T, not RSA", path, key)
+	}
+	return certificate, rsaKey, nil
+}
+
+// environment returns the configured Azure cloud, defaulting to the public cloud.
+func environment(cfg Config) azure.Environment {
+	if cfg.Environment.Name == "" {
+		return azure.PublicCloud
+	}
+	return cfg.Environment
+}
+
+// vaultResource returns the OAuth resource tokens are requested for, e.g. https://vault.azure.net
+// in the public cloud or https://vault.usgovcloudapi.net in Azure US Government.
+func vaultResource(env azure.Environment) string {
+	return strings.TrimSuffix(env.KeyVaultEndpoint, "/")
+}
@@ -0,0 +1,106 @@
+package keyvaultclient
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// BenchmarkTenantOAuthConfig compares a cached OAuth configuration with building one, as every authorizer
+// did before the cache.
+func BenchmarkTenantOAuthConfig(b *testing.B) {
+	endpoint := azure.PublicCloud.ActiveDirectoryEndpoint
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := tenantOAuthConfig(endpoint, "benchmark-tenant"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			oauthConfigs.Lock()
+			oauthConfigs.m = map[[2]string]*adal.OAuthConfig{}
+			oauthConfigs.Unlock()
+			b.StartTimer()
+			if _, err := tenantOAuthConfig(endpoint, "benchmark-tenant"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestSharedAuthorizerAuthenticatesOutsideTheLock holds one Service Principal's token request until the
+// others have been served: Clients with other credentials mustn't wait for it, and ones with the same
+// credentials must wait for it rather than request their own.
+func TestSharedAuthorizerAuthenticatesOutsideTheLock(t *testing.T) {
+	stub := newTokenStub(t)
+	slowRequested := make(chan struct{})
+	release := make(chan struct{})
+	var slowRequests int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") == "slow" {
+			if atomic.AddInt32(&slowRequests, 1) == 1 {
+				close(slowRequested)
+			}
+			<-release
+		}
+		stub.ServeHTTP(w, r)
+	}))
+	server.TLS = &tls.Config{}
+	server.StartTLS()
+	defer server.Close()
+	client := serverForEveryHost(server)
+	newConfig := func(clientID string) Config {
+		return Config{
+			TenantID:          "tenant",
+			ClientID:          clientID,
+			ClientSecret:      "secret",
+			DisableTokenCache: true,
+			MaxRetries:        -1,
+			HTTPClient:        client,
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := New(newConfig("slow")); err != nil {
+				t.Errorf("New with the slow credentials: %v", err)
+			}
+		}()
+	}
+	<-slowRequested
+
+	fast := make(chan error, 1)
+	go func() {
+		_, err := New(newConfig("fast"))
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Errorf("New with other credentials: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("New with other credentials waited for another Service Principal's token request")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&slowRequests); n != 1 {
+		t.Errorf("Clients with the same credentials made %d token requests, want 1", n)
+	}
+}
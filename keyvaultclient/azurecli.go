@@ -0,0 +1,101 @@
+package keyvaultclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// azureCLIToken is the subset of `az account get-access-token` output we use.
+type azureCLIToken struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresOn   string `json:"expiresOn"`
+	TokenType   string `json:"tokenType"`
+}
+
+// azureCLITokenProvider hands out the token the Azure CLI holds for the logged in user, asking the CLI
+// again once it is within refreshSkew of expiry. If the CLI still has nothing newer, it isn't asked again
+// until the token has expired.
+type azureCLITokenProvider struct {
+	azPath      string
+	resource    string
+	refreshSkew time.Duration
+
+	mu          sync.Mutex
+	token       string
+	expiresOn   time.Time
+	refreshedAt time.Time // when the CLI was last asked
+}
+
+// getAzureCLIAuthorizer authenticates as whoever is logged in with `az login`.
+func getAzureCLIAuthorizer(azPath string, resource string, refreshSkew time.Duration) (autorest.Authorizer, error) {
+	defer timeTrack(time.Now(), "getAzureCLIAuthorizer")
+	tp := &azureCLITokenProvider{azPath: azPath, resource: resource, refreshSkew: refreshSkew}
+	if err := tp.refresh(); err != nil {
+		return nil, err
+	}
+	return newTokenAuthorizer(tp), nil
+}
+
+// OAuthToken implements adal.OAuthTokenProvider.
+func (tp *azureCLITokenProvider) OAuthToken() string {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	now := time.Now()
+	refreshFrom := tp.expiresOn.Add(-tp.refreshSkew)
+	if now.After(tp.expiresOn) || (now.After(refreshFrom) && tp.refreshedAt.Before(refreshFrom)) {
+		if err := tp.refreshLocked(); err != nil {
+			log.Warnf("Could not refresh token from the Azure CLI: %v", err)
+		}
+	}
+	return tp.token
+}
+
+func (tp *azureCLITokenProvider) expiry() time.Time {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.expiresOn
+}
+
+func (tp *azureCLITokenProvider) refresh() error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.refreshLocked()
+}
+
+func (tp *azureCLITokenProvider) refreshLocked() (err error) {
+	_, span := tracer.Start(context.Background(), "AzureCLIGetAccessToken")
+	defer func() { endSpan(span, err) }()
+	tp.refreshedAt = time.Now()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(tp.azPath, "account", "get-access-token", "--resource", tp.resource, "--output", "json")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("az account get-access-token failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var token azureCLIToken
+	if err := json.Unmarshal(out, &token); err != nil {
+		return fmt.Errorf("Could not parse az account get-access-token output: %v", err)
+	}
+	// The CLI reports expiresOn in local time, e.g. "2018-03-01 17:21:54.000000".
+	expiresOn, err := time.ParseInLocation("2006-01-02 15:04:05.999999", token.ExpiresOn, time.Local)
+	if err != nil {
+		return fmt.Errorf("Could not parse token expiry %q: %v", token.ExpiresOn, err)
+	}
+
+	tp.token = token.AccessToken
+	tp.expiresOn = expiresOn
+	log.Debugf("Got token from the Azure CLI. expiresOn=%s", expiresOn.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,86 @@
+package keyvaultclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAzureCLI writes an az that prints each of tokens in turn, one per run, and returns its path and a
+// function returning how often it has run.
+func fakeAzureCLI(t *testing.T, tokens ...azureCLIToken) (string, func() int) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake az is a shell script")
+	}
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	outputs := filepath.Join(dir, "outputs")
+	var lines []string
+	for _, token := range tokens {
+		line, err := json.Marshal(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+	if err := os.WriteFile(outputs, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\necho run >> %q\nsed -n \"$(wc -l < %q)p\" %q\n", runs, runs, outputs)
+	az := filepath.Join(dir, "az")
+	if err := os.WriteFile(az, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return az, func() int {
+		data, _ := os.ReadFile(runs)
+		return strings.Count(string(data), "\n")
+	}
+}
+
+// cliToken is accessToken as the Azure CLI reports it, expiring at expiresOn.
+func cliToken(accessToken string, expiresOn time.Time) azureCLIToken {
+	return azureCLIToken{AccessToken: accessToken, TokenType: "Bearer", ExpiresOn: expiresOn.Local().Format("2006-01-02 15:04:05.000000")}
+}
+
+func TestAzureCLITokenRefreshesWithinSkew(t *testing.T) {
+	// The first token enters the skew a second after it is issued.
+	now := time.Now()
+	az, runs := fakeAzureCLI(t, cliToken("first", now.Add(3*time.Second)), cliToken("second", now.Add(time.Hour)))
+	tp := &azureCLITokenProvider{azPath: az, resource: "https://vault.azure.net", refreshSkew: 2 * time.Second}
+	if err := tp.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tp.OAuthToken(); got != "first" {
+		t.Errorf("OAuthToken() = %q at once, want %q", got, "first")
+	}
+	time.Sleep(time.Until(now.Add(1500 * time.Millisecond)))
+	if got := tp.OAuthToken(); got != "second" {
+		t.Errorf("OAuthToken() = %q within the skew, want the refreshed %q", got, "second")
+	}
+	if n := runs(); n != 2 {
+		t.Errorf("az ran %d time(s), want 2", n)
+	}
+}
+
+func TestAzureCLITokenWithinSkewIsNotRefreshedAgain(t *testing.T) {
+	// The CLI only has a token expiring within the skew; asking again at once wouldn't get a better one.
+	az, runs := fakeAzureCLI(t, cliToken("first", time.Now().Add(2*time.Minute)), cliToken("second", time.Now().Add(time.Hour)))
+	tp := &azureCLITokenProvider{azPath: az, resource: "https://vault.azure.net", refreshSkew: DefaultTokenRefreshSkew}
+	if err := tp.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if got := tp.OAuthToken(); got != "first" {
+			t.Errorf("OAuthToken() = %q, want %q until it expires", got, "first")
+		}
+	}
+	if n := runs(); n != 1 {
+		t.Errorf("az ran %d time(s), want 1", n)
+	}
+}
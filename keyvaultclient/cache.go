@@ -0,0 +1,85 @@
+package keyvaultclient
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretCache holds secrets read by GetSecretDetails for Config.SecretCacheTTL, keyed by vault, name
+// and version as requested, so "" (the current version) is cached separately from explicit versions.
+// A nil *secretCache caches nothing.
+type secretCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[SecretRef]cachedSecret
+}
+
+type cachedSecret struct {
+	secret  Secret
+	expires time.Time
+}
+
+func newSecretCache(ttl time.Duration) *secretCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &secretCache{ttl: ttl, entries: map[SecretRef]cachedSecret{}}
+}
+
+func (c *secretCache) get(ref SecretRef) (Secret, bool) {
+	if c == nil {
+		return Secret{}, false
+	}
+	ref = cacheKey(ref)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[ref]
+	if !ok {
+		return Secret{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, ref)
+		return Secret{}, false
+	}
+	return entry.secret, true
+}
+
+func (c *secretCache) put(ref SecretRef, secret Secret) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(ref)] = cachedSecret{secret: secret, expires: time.Now().Add(c.ttl)}
+}
+
+// invalidate drops every cached version of a secret.
+func (c *secretCache) invalidate(vaultBaseURL string, secretName string) {
+	if c == nil {
+		return
+	}
+	key := cacheKey(SecretRef{VaultBaseURL: vaultBaseURL, Name: secretName})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ref := range c.entries {
+		if ref.VaultBaseURL == key.VaultBaseURL && ref.Name == key.Name {
+			delete(c.entries, ref)
+		}
+	}
+}
+
+// cacheKey normalizes the parts of a reference Key Vault treats as equivalent: a trailing slash on the
+// vault URL and the case of the secret name.
+func cacheKey(ref SecretRef) SecretRef {
+	ref.VaultBaseURL = strings.TrimSuffix(ref.VaultBaseURL, "/")
+	ref.Name = strings.ToLower(ref.Name)
+	return ref
+}
+
+// InvalidateSecret drops every cached version of a secret, so the next read fetches it from the vault.
+// Call it after changing a secret elsewhere. It does nothing when Config.SecretCacheTTL is zero.
+func (c *Client) InvalidateSecret(vaultBaseURL string, secretName string) {
+	c.cache.invalidate(vaultBaseURL, secretName)
+}
@@ -0,0 +1,273 @@
+package keyvaultclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// Content types Key Vault uses for the secret backing a certificate.
+const (
+	contentTypePKCS12 = "application/x-pkcs12"
+	contentTypePEM    = "application/x-pem-file"
+)
+
+// Certificate is the public part of a certificate stored in Key Vault.
+type Certificate struct {
+	Name    string
+	Version string
+	// Cer is the DER encoded X.509 certificate.
+	Cer []byte
+	// Policy describes how the certificate is issued and renewed, including whether its key is exportable.
+	Policy *keyvault.CertificatePolicy
+	// SecretVersion is the version of the secret holding the certificate together with its private key.
+	SecretVersion string
+}
+
+// CertificateInfo is a certificate as listed, without its contents. NotBefore and Expires are the validity
+// period of its current version; they are zero when Key Vault didn't report them.
+type CertificateInfo struct {
+	ID         string
+	Name       string
+	Enabled    bool
+	NotBefore  time.Time
+	Expires    time.Time
+	Thumbprint string
+}
+
+// ListCertificates returns every certificate in the vault, disabled ones included, sorted by name. The
+// validity period comes with the list itself, so no request is made per certificate. A vault without
+// certificates returns an empty list.
+func (c *Client) ListCertificates(ctx context.Context, vaultBaseURL string) ([]CertificateInfo, error) {
+	defer timeTrack(time.Now(), "listCertificates")
+	var certs []CertificateInfo
+	err := c.do(ctx, "ListCertificates", func(ctx context.Context) error {
+		certs = nil
+		page, err := c.kv.GetCertificates(ctx, vaultBaseURL, nil)
+		if err != nil {
+			return err
+		}
+		for page.NotDone() {
+			for _, item := range page.Values() {
+				if item.ID == nil {
+					continue
+				}
+				info := CertificateInfo{ID: *item.ID, Name: objectNameFromID(*item.ID, "certificates")}
+				if item.Attributes != nil {
+					info.Enabled = item.Attributes.Enabled != nil && *item.Attributes.Enabled
+					info.NotBefore = unixTime(item.Attributes.NotBefore)
+					info.Expires = unixTime(item.Attributes.Expires)
+				}
+				if item.X509Thumbprint != nil {
+					info.Thumbprint = *item.X509Thumbprint
+				}
+				certs = append(certs, info)
+			}
+			err = nextPage(ctx, page.Next)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Name < certs[j].Name })
+	return certs, nil
+}
+
+// GetCertificate returns a certificate and its policy. An empty version returns the current version.
+// The private key is not included; see ExportCertificatePEM.
+func (c *Client) GetCertificate(ctx context.Context, vaultBaseURL string, name string, version string) (Certificate, error) {
+	defer timeTrack(time.Now(), "getCertificate")
+	var bundle keyvault.CertificateBundle
+	err := c.do(ctx, "GetCertificate "+name, func(ctx context.Context) (err error) {
+		bundle, err = c.kv.GetCertificate(ctx, vaultBaseURL, name, version)
+		return err
+	})
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	cert := Certificate{Name: name, Policy: bundle.Policy}
+	if bundle.ID != nil {
+		cert.Version = objectVersionFromID(*bundle.ID, "certificates")
+	}
+	if bundle.Cer != nil {
+		cert.Cer = *bundle.Cer
+	}
+	if bundle.Sid != nil {
+		cert.SecretVersion = SecretVersionFromID(*bundle.Sid)
+	}
+	return cert, nil
+}
+
+// ExportCertificatePEM returns the certificate, its chain and its private key as PEM blocks, read from
+// the secret Key Vault keeps alongside every certificate. The secret holds either a PKCS#12 archive or
+// PEM text depending on the content type in the certificate's policy; both are returned as PEM. The
+// private key is only present when the policy marks it exportable.
+func (c *Client) ExportCertificatePEM(ctx context.Context, vaultBaseURL string, name string, version string) ([]byte, error) {
+	defer timeTrack(time.Now(), "exportCertificatePEM")
+	cert, err := c.GetCertificate(ctx, vaultBaseURL, name, version)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := c.GetSecretDetails(ctx, vaultBaseURL, name, cert.SecretVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	switch secret.ContentType {
+	case contentTypePEM:
+		return []byte(secret.Value), nil
+	case contentTypePKCS12:
+		return pkcs12ToPEM(string(secret.Value))
+	default:
+		return nil, fmt.Errorf("certificate %s has unsupported content type %q", name, secret.ContentType)
+	}
+}
+
+// PFXOptions controls GetCertificateAsPFX.
+type PFXOptions struct {
+	// Password, when set, encrypts the archive with it. Key Vault's own archive has no password.
+	Password string
+	// Legacy encrypts with 3DES and SHA-1, for Windows before Server 2019 and Java before 8u301, rather
+	// than with AES-256 and SHA-256.
+	Legacy bool
+}
+
+// GetCertificateAsPFX returns the certificate, its chain and its private key as a PKCS#12 (.pfx) archive,
+// as Windows and Java keystores import. It is read from the secret Key Vault keeps alongside the
+// certificate, converted from PEM when the policy stores the certificate as PEM. Without opts.Password
+// Key Vault's passwordless archive is returned as it is. A certificate whose key isn't exportable fails
+// with ErrNotExportable.
+func (c *Client) GetCertificateAsPFX(ctx context.Context, vaultBaseURL string, name string, version string, opts PFXOptions) ([]byte, error) {
+	defer timeTrack(time.Now(), "getCertificateAsPFX")
+	cert, err := c.GetCertificate(ctx, vaultBaseURL, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if p := cert.Policy; p != nil && p.KeyProperties != nil && p.KeyProperties.Exportable != nil && !*p.KeyProperties.Exportable {
+		return nil, fmt.Errorf("Could not export certificate %s: %w", name, ErrNotExportable)
+	}
+	secret, err := c.GetSecretDetails(ctx, vaultBaseURL, name, cert.SecretVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	var leaf *x509.Certificate
+	var chain []*x509.Certificate
+	switch secret.ContentType {
+	case contentTypePKCS12:
+		pfx, err := base64.StdEncoding.DecodeString(string(secret.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode PKCS#12 data: %v", err)
+		}
+		if opts.Password == "" && !opts.Legacy {
+			return pfx, nil
+		}
+		key, leaf, chain, err = pkcs12.DecodeChain(pfx, "")
+		if err != nil {
+			return nil, fmt.Errorf("Could not read PKCS#12 data: %v", err)
+		}
+	case contentTypePEM:
+		key, leaf, chain, err = parsePEMCertificate([]byte(secret.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read certificate %s: %v", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("certificate %s has unsupported content type %q", name, secret.ContentType)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("Could not export certificate %s: %w", name, ErrNotExportable)
+	}
+
+	encoder := pkcs12.Modern
+	if opts.Legacy {
+		encoder = pkcs12.Legacy
+	}
+	pfx, err := encoder.Encode(key, leaf, chain, opts.Password)
+	if err != nil {
+		return nil, fmt.Errorf("Could not encode certificate %s as PKCS#12: %v", name, err)
+	}
+	return pfx, nil
+}
+
+// parsePEMCertificate reads the private key and certificates of a PEM secret. The first certificate is the
+// certificate's own; the others are its chain.
+func parsePEMCertificate(data []byte) (key interface{}, leaf *x509.Certificate, chain []*x509.Certificate, err error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if leaf == nil {
+				leaf = cert
+			} else {
+				chain = append(chain, cert)
+			}
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if leaf == nil {
+		return nil, nil, nil, errors.New("no certificate in the PEM data")
+	}
+	return key, leaf, chain, nil
+}
+
+// pkcs12ToPEM converts the base64 encoded, passwordless PKCS#12 archive Key Vault stores into PEM.
+func pkcs12ToPEM(value string) ([]byte, error) {
+	pfx, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode PKCS#12 data: %v", err)
+	}
+	blocks, err := pkcs12.ToPEM(pfx, "")
+	if err != nil {
+		return nil, fmt.Errorf("Could not read PKCS#12 data: %v", err)
+	}
+
+	var out bytes.Buffer
+	for _, block := range blocks {
+		// ToPEM labels every key "PRIVATE KEY" although it encodes RSA keys as PKCS#1 and EC keys as SEC 1,
+		// and copies the PKCS#12 bag attributes into headers many PEM readers reject.
+		block.Headers = nil
+		if block.Type == "PRIVATE KEY" {
+			if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+				block.Type = "RSA PRIVATE KEY"
+			} else {
+				block.Type = "EC PRIVATE KEY"
+			}
+		}
+		if err := pem.Encode(&out, block); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,201 @@
+package keyvaultclient
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Defaults for the circuit breaker when Config.CircuitBreakerThreshold enables it.
+const (
+	DefaultCircuitBreakerWindow   = time.Minute
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// circuitState is where a vault host's circuit stands.
+type circuitState int
+
+const (
+	// circuitClosed lets requests through, counting consecutive throttled responses.
+	circuitClosed circuitState = iota
+	// circuitOpen fails requests without sending them until the cooldown is over.
+	circuitOpen
+	// circuitHalfOpen lets one probe request through, whose response closes or reopens the circuit.
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// circuit is the breaker state of one vault host.
+type circuit struct {
+	state     circuitState
+	throttled int       // consecutive throttled responses while closed
+	since     time.Time // when the first of them arrived
+	openUntil time.Time
+	probing   bool // a half-open probe is in flight
+}
+
+// circuitBreakerSender stops sending requests to a vault host once it has throttled threshold requests
+// in a row within window, so retries don't add to the load that got them throttled. Requests to the
+// host then fail fast with ErrCircuitOpen for the cooldown, or for as long as the last response's
+// Retry-After asked if that is longer. A single probe request is let through after that; the circuit
+// closes if it isn't throttled and opens again if it is.
+type circuitBreakerSender struct {
+	sender    autorest.Sender
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// newCircuitBreakerSender wraps sender with a breaker configured by cfg. It returns sender unchanged
+// when Config.CircuitBreakerThreshold is zero.
+func newCircuitBreakerSender(sender autorest.Sender, cfg Config) autorest.Sender {
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return sender
+	}
+	s := &circuitBreakerSender{
+		sender:    sender,
+		threshold: cfg.CircuitBreakerThreshold,
+		window:    cfg.CircuitBreakerWindow,
+		cooldown:  cfg.CircuitBreakerCooldown,
+		circuits:  map[string]*circuit{},
+	}
+	if s.window <= 0 {
+		s.window = DefaultCircuitBreakerWindow
+	}
+	if s.cooldown <= 0 {
+		s.cooldown = DefaultCircuitBreakerCooldown
+	}
+	return s
+}
+
+func (s *circuitBreakerSender) Do(r *http.Request) (*http.Response, error) {
+	host := r.URL.Hostname()
+	if err := s.allow(host, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := s.sender.Do(r)
+	s.record(host, resp, err, time.Now())
+	return resp, err
+}
+
+// allow reports whether a request to host may be sent now, moving an open circuit whose cooldown is
+// over to half-open for the request to probe it.
+func (s *circuitBreakerSender) allow(host string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.circuit(host)
+	switch c.state {
+	case circuitOpen:
+		if now.Before(c.openUntil) {
+			return circuitOpenError{host: host, until: c.openUntil}
+		}
+		s.transition(host, c, circuitHalfOpen)
+		c.probing = true
+	case circuitHalfOpen:
+		if c.probing {
+			return circuitOpenError{host: host, until: now}
+		}
+		c.probing = true
+	}
+	return nil
+}
+
+// record updates host's circuit with the outcome of a request sent to it. Only throttling counts against
+// the host: other failures, such as a network error, neither open nor close the circuit.
+func (s *circuitBreakerSender) record(host string, resp *http.Response, err error, now time.Time) {
+	throttled := resp != nil && resp.StatusCode == http.StatusTooManyRequests
+	if !throttled && (err != nil || resp == nil) {
+		s.mu.Lock()
+		s.circuit(host).probing = false
+		s.mu.Unlock()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.circuit(host)
+	c.probing = false
+	switch {
+	case !throttled:
+		if c.state != circuitClosed {
+			s.transition(host, c, circuitClosed)
+		}
+		c.throttled = 0
+	case c.state == circuitHalfOpen:
+		s.open(host, c, resp, now)
+	default:
+		if c.throttled == 0 || now.Sub(c.since) > s.window {
+			c.throttled, c.since = 0, now
+		}
+		c.throttled++
+		if c.throttled >= s.threshold {
+			s.open(host, c, resp, now)
+		}
+	}
+}
+
+// open opens c for the cooldown, or until the Retry-After of resp when that is later.
+func (s *circuitBreakerSender) open(host string, c *circuit, resp *http.Response, now time.Time) {
+	cooldown := s.cooldown
+	if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok && d > cooldown {
+		cooldown = d
+	}
+	c.openUntil = now.Add(cooldown)
+	c.throttled = 0
+	s.transition(host, c, circuitOpen)
+}
+
+func (s *circuitBreakerSender) transition(host string, c *circuit, to circuitState) {
+	switch to {
+	case circuitOpen:
+		log.Warnf("Circuit breaker open, failing requests fast. host=%s from=%s until=%s", host, c.state, c.openUntil.Format(time.RFC3339))
+	default:
+		log.Infof("Circuit breaker %s. host=%s from=%s", to, host, c.state)
+	}
+	c.state = to
+}
+
+// circuit returns host's circuit, creating a closed one. s.mu must be held.
+func (s *circuitBreakerSender) circuit(host string) *circuit {
+	c, ok := s.circuits[host]
+	if !ok {
+		c = &circuit{}
+		s.circuits[host] = c
+	}
+	return c
+}
+
+// circuitOpenError fails a request the circuit breaker didn't send. It matches ErrCircuitOpen and,
+// as the vault was throttling, ErrThrottled.
+type circuitOpenError struct {
+	host  string
+	until time.Time
+}
+
+func (e circuitOpenError) Error() string {
+	if wait := time.Until(e.until); wait > 0 {
+		return fmt.Sprintf("%s: %v for another %s after repeated throttling", e.host, ErrCircuitOpen, wait.Round(time.Second))
+	}
+	return fmt.Sprintf("%s: %v while a probe request checks whether throttling has ended", e.host, ErrCircuitOpen)
+}
+
+func (e circuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen || target == ErrThrottled
+}
@@ -0,0 +1,253 @@
+// Package keyvaultclient reads and writes Azure Key Vault secrets, authenticating either as a
+// Service Principal (with the OAuth token cached on disk between runs) or as a managed identity.
+package keyvaultclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// Config holds the credentials used to authorize requests against Key Vault.
+type Config struct {
+	// AuthMethod is "secret" (the default when empty), "cert", "federated", "devicecode", "msi" or "cli".
+	// "cert" and "federated" authenticate the Service Principal with ClientCertPath or FederatedTokenFile
+	// instead of ClientSecret. "devicecode" signs a user in through the browser, as the Azure CLI's public
+	// client unless ClientID names another. "cli" uses the token of the user logged in with `az login`,
+	// falling back to the Service Principal when the Azure CLI isn't installed.
+	AuthMethod string
+
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+
+	// ClientCertPath is a PKCS#12 (.pfx) file holding the Service Principal's certificate and RSA private
+	// key, used when AuthMethod is "cert". ClientCertPassword decrypts it and may be empty.
+	ClientCertPath     string
+	ClientCertPassword string
+
+	// FederatedTokenFile holds the OIDC token exchanged for an Azure AD token when AuthMethod is
+	// "federated", such as the one projected into pods by Azure AD Workload Identity. It is reread
+	// whenever the token is refreshed.
+	FederatedTokenFile string
+
+	// DeviceCodeOutput is where the device code sign in instructions are written. Nil means os.Stderr.
+	DeviceCodeOutput io.Writer
+
+	// DisableTokenCache skips reading and writing the token cache, always requesting a fresh token.
+	DisableTokenCache bool
+
+	// ForceTokenRefresh ignores the cached token, and any authorizer already shared by Clients with the
+	// same credentials, requesting a fresh token that then replaces the cached one. It is for use after
+	// the credentials change, as when a client secret is rotated.
+	ForceTokenRefresh bool
+
+	// TokenCacheDir is where the Service Principal token is cached. When empty it is a goAzureKeyVault
+	// directory under os.UserCacheDir.
+	TokenCacheDir string
+
+	// TokenCacheKey, when set, is the passphrase the cached Service Principal token is encrypted with.
+	// When empty the token is cached in plaintext.
+	TokenCacheKey string
+
+	// TokenRefreshSkew is how long before expiry a token is refreshed, so a request never goes out with
+	// one that runs out in flight. Zero means DefaultTokenRefreshSkew.
+	TokenRefreshSkew time.Duration
+
+	// Environment is the Azure cloud the vault lives in. The zero value means azure.PublicCloud. For a
+	// private cloud such as Azure Stack Hub, fill in its ActiveDirectoryEndpoint, KeyVaultEndpoint (the
+	// resource tokens are requested for) and KeyVaultDNSSuffix.
+	Environment azure.Environment
+
+	// TokenEndpoint, when set, is the full URL Service Principal tokens are requested from instead of
+	// <Environment.ActiveDirectoryEndpoint><TenantID>/oauth2/token, e.g. an AD FS endpoint.
+	TokenEndpoint string
+
+	// HTTPClient sends every request to Key Vault and Azure AD. Nil means a client with the default
+	// connection timeouts that goes through the proxy named by HTTPS_PROXY. NewHTTPClientWithOptions builds
+	// one with other timeouts or trusting a corporate CA bundle, and NewInsecureHTTPClient one for testing
+	// against an emulator.
+	HTTPClient *http.Client
+
+	// MSIClientID selects a user-assigned managed identity. When empty the system-assigned identity is used.
+	MSIClientID string
+
+	// MaxConcurrency bounds the number of requests GetSecrets has in flight. Zero means DefaultMaxConcurrency.
+	MaxConcurrency int
+
+	// RequestTimeout bounds each call to Key Vault. Zero means no timeout beyond the caller's context.
+	RequestTimeout time.Duration
+
+	// OperationDeadline bounds each operation as a whole, such as reading one secret or one page of a list:
+	// every attempt, the waits between retries and for the rate limit included, so MaxRetries can't stretch
+	// it. Once it is used up the operation fails with an error naming it, matching context.DeadlineExceeded.
+	// Zero means no bound beyond RequestTimeout per attempt and the caller's context.
+	OperationDeadline time.Duration
+
+	// MaxRetries is how many times throttled or transiently failed calls are retried. Zero means
+	// DefaultMaxRetries; a negative value disables retries.
+	MaxRetries int
+
+	// RequestsPerSecond caps the rate of requests to Key Vault across all of the client's operations, to
+	// stay under the vault's transaction limits in bulk jobs. Zero means no limit.
+	RequestsPerSecond float64
+
+	// CircuitBreakerThreshold, when positive, stops sending requests to a vault host once it has throttled
+	// this many in a row within CircuitBreakerWindow. They then fail with ErrCircuitOpen for
+	// CircuitBreakerCooldown, or longer if Key Vault's Retry-After asks, after which one probe request
+	// decides whether to resume. Zero disables the breaker.
+	CircuitBreakerThreshold int
+	// CircuitBreakerWindow is how close together the throttled responses must come. Zero means
+	// DefaultCircuitBreakerWindow.
+	CircuitBreakerWindow time.Duration
+	// CircuitBreakerCooldown is how long the breaker stays open. Zero means DefaultCircuitBreakerCooldown.
+	CircuitBreakerCooldown time.Duration
+
+	// SecretCacheTTL is how long secrets read with GetSecret and its variants are served from memory
+	// before being fetched again. Zero disables the cache.
+	SecretCacheTTL time.Duration
+
+	// AuditLog, when set, receives a JSON line for every secret read with GetSecret and its variants: the
+	// auth method and client ID, the vault, secret and version, whether it came from the cache and whether
+	// it succeeded. Values are never written. Writes to it must be safe for concurrent use, as an
+	// *os.File's are.
+	AuditLog io.Writer
+
+	// StrictExpiry makes GetSecret and its variants fail with ErrSecretExpired or ErrSecretNotYetActive
+	// for a secret outside its Expires or NotBefore time. Otherwise the secret is returned and a warning logged.
+	StrictExpiry bool
+}
+
+// DefaultTokenRefreshSkew is how long before expiry tokens are refreshed when Config.TokenRefreshSkew is zero.
+const DefaultTokenRefreshSkew = 5 * time.Minute
+
+// DefaultMaxConcurrency is the number of concurrent requests GetSecrets makes when Config.MaxConcurrency is zero.
+const DefaultMaxConcurrency = 8
+
+// SecretGetter reads a secret. Its signature matches keyvault.BaseClient.GetSecret, so the SDK client
+// satisfies it; tests can substitute a fake through NewWithSecretGetter.
+type SecretGetter interface {
+	GetSecret(ctx context.Context, vaultBaseURL string, secretName string, secretVersion string) (keyvault.SecretBundle, error)
+}
+
+// Client is an authorized Key Vault client. It is safe for concurrent use by multiple goroutines,
+// including while its token is being refreshed, so a service should create one and share it.
+type Client struct {
+	kv             keyvault.BaseClient
+	secrets        SecretGetter
+	maxConcurrency int
+	requestTimeout time.Duration
+	deadline       time.Duration
+	maxRetries     int
+	cache          *secretCache
+	strictExpiry   bool
+	audit          *auditLogger
+	env            azure.Environment
+	authorizer     *vaultAuthorizer
+}
+
+// New creates a Client, acquiring (or loading from the cache) the token needed to talk to Key Vault.
+func New(cfg Config) (*Client, error) {
+	return NewContext(context.Background(), cfg)
+}
+
+// NewContext is New, with the authentication traced as part of ctx.
+func NewContext(ctx context.Context, cfg Config) (*Client, error) {
+	return NewWithTokenProvider(&ConfigTokenProvider{Config: cfg, ctx: ctx}, cfg)
+}
+
+// NewWithTokenProvider creates a Client that authorizes its requests with the authorizer tp supplies,
+// rather than the one cfg's credentials would. Everything else, from retries to the HTTP client, follows cfg.
+func NewWithTokenProvider(tp TokenProvider, cfg Config) (*Client, error) {
+	authorizer, err := tp.Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+
+	kv := keyvault.New()
+	kv.Authorizer = authorizer
+	kv.RequestInspector = withManagedHSM(environment(cfg))
+	// Retries are handled by Client.do so that they honor MaxRetries and back off with jitter. autorest
+	// still sleeps RetryDuration after a retryable status on the last attempt, ignoring the request's
+	// context, which would hold every failed operation for 30s beyond any deadline.
+	kv.RetryAttempts = 0
+	kv.RetryDuration = 0
+	kv.Sender = throttleSender{sender: newCircuitBreakerSender(newRateLimitSender(httpClient(cfg), cfg.RequestsPerSecond), cfg)}
+	c := newClient(kv, kv, cfg)
+	c.authorizer, _ = authorizer.(*vaultAuthorizer)
+	return c, nil
+}
+
+// NewWithSecretGetter creates a Client whose GetSecret, GetSecretDetails, GetSecrets and GetSecretsDetails
+// read secrets through getter rather than from Key Vault, applying cfg's concurrency, timeout and retry
+// settings. Nothing is authorized, so the client's other operations fail.
+func NewWithSecretGetter(getter SecretGetter, cfg Config) *Client {
+	return newClient(keyvault.New(), getter, cfg)
+}
+
+func newClient(kv keyvault.BaseClient, getter SecretGetter, cfg Config) *Client {
+	maxConcurrency := cfg.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultMaxConcurrency
+	}
+	return &Client{
+		kv:             kv,
+		secrets:        getter,
+		maxConcurrency: maxConcurrency,
+		requestTimeout: cfg.RequestTimeout,
+		deadline:       cfg.OperationDeadline,
+		maxRetries:     maxRetries(cfg),
+		cache:          newSecretCache(cfg.SecretCacheTTL),
+		strictExpiry:   cfg.StrictExpiry,
+		audit:          newAuditLogger(cfg),
+		env:            environment(cfg),
+	}
+}
+
+// defaultHTTPClient is used when Config.HTTPClient is nil. Sharing it lets Clients reuse its connections.
+var defaultHTTPClient = &http.Client{Transport: newTransport(TransportOptions{})}
+
+// httpClient returns Config.HTTPClient, or a client with the default connection timeouts when it is nil.
+func httpClient(cfg Config) *http.Client {
+	if cfg.HTTPClient != nil {
+		return cfg.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+// withTimeout bounds ctx by Config.RequestTimeout, when one is set.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.requestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.requestTimeout)
+}
+
+// timeoutError replaces err with one that says the operation timed out when ctx's deadline has passed,
+// so a hung request isn't reported as a generic failure. The result still matches context.DeadlineExceeded.
+func timeoutError(ctx context.Context, operation string, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out: %w", operation, context.DeadlineExceeded)
+	}
+	return err
+}
+
+// timeTrack records how long the operation name took since start, for metrics and TimingSummary, and
+// logs it at DEBUG.
+func timeTrack(start time.Time, name string) {
+	elapsed := time.Since(start)
+	observeDuration(name, elapsed)
+	recordTiming(name, elapsed)
+	log.WithFields(log.Fields{
+		"function":    name,
+		"elapsed(ns)": elapsed.Nanoseconds(),
+		"elapsed":     elapsed.String(),
+	}).Debug("Timings")
+}
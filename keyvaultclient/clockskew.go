@@ -0,0 +1,40 @@
+package keyvaultclient
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxClockSkew is how far the local clock may be from the time Azure reports before it is warned about.
+// Azure AD tolerates about five minutes in token lifetimes; beyond a couple, cached tokens are judged
+// fresh or expired at the wrong time, which shows up as otherwise unexplained 401s.
+const maxClockSkew = 2 * time.Minute
+
+// clockSkewWarning makes sure the skew is only warned about once per process.
+var clockSkewWarning sync.Once
+
+// checkClockSkew compares the local clock with the Date header of a response from Azure AD or Key
+// Vault, and warns when they are more than maxClockSkew apart.
+func checkClockSkew(resp *http.Response) {
+	if resp == nil || resp.Request == nil {
+		return
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	skew := time.Since(date)
+	if skew < 0 {
+		skew = -skew
+	}
+	log.Debugf("Clock skew against %s. skew=%s", resp.Request.URL.Hostname(), skew.Round(time.Second))
+	if skew > maxClockSkew {
+		clockSkewWarning.Do(func() {
+			log.Warnf("The local clock is %s off the time reported by %s. Token expiry is judged by the local clock, so tokens may be rejected as expired or not yet valid; check the machine's time synchronization.",
+				skew.Round(time.Second), resp.Request.URL.Hostname())
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package keyvaultclient
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// deviceCodeClientID is the Azure CLI's public client application, which users can sign in to Key Vault
+// through without an app registration of their own. Its tenant-independent sign in uses deviceCodeTenant.
+const (
+	deviceCodeClientID = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+	deviceCodeTenant   = "common"
+)
+
+// deviceCodeConfig fills in the public client and tenant the device code flow uses when none are configured.
+func deviceCodeConfig(cfg Config) Config {
+	if cfg.ClientID == "" {
+		cfg.ClientID = deviceCodeClientID
+	}
+	if cfg.TenantID == "" {
+		cfg.TenantID = deviceCodeTenant
+	}
+	return cfg
+}
+
+// getDeviceCodeAuthorizer signs the user in interactively: it prints a code to enter at a URL in any
+// browser and waits until they have. The token comes with a refresh token, so once it is cached later
+// runs, and refreshes near expiry, need no interaction until the refresh token itself expires.
+func getDeviceCodeAuthorizer(oauthConfig adal.OAuthConfig, cfg Config, resource string, cached *adal.Token, saveToken adal.TokenRefreshCallback) (autorest.Authorizer, error) {
+	sender := tracingSender{sender: httpClient(cfg)}
+	newToken := func(token adal.Token) (*adal.ServicePrincipalToken, error) {
+		spt, err := adal.NewServicePrincipalTokenFromManualToken(oauthConfig, cfg.ClientID, resource, token, saveToken)
+		if err != nil {
+			return nil, err
+		}
+		spt.SetSender(sender)
+		spt.SetRefreshWithin(tokenRefreshSkew(cfg))
+		return spt, nil
+	}
+
+	if cached != nil && cached.RefreshToken != "" {
+		spt, err := newToken(*cached)
+		if err == nil {
+			err = spt.EnsureFresh()
+		}
+		if err == nil {
+			return newTokenAuthorizer(newSyncRefresher(spt, tokenRefreshSkew(cfg))), nil
+		}
+		log.Warnf("Could not use the cached refresh token, signing in again: %v", err)
+	}
+
+	defer timeTrack(time.Now(), "DeviceCodeSignIn")
+	code, err := adal.InitiateDeviceAuth(sender, oauthConfig, cfg.ClientID, resource)
+	if err != nil {
+		return nil, fmt.Errorf("Could not start the device code sign in: %v", err)
+	}
+	out := cfg.DeviceCodeOutput
+	if out == nil {
+		out = os.Stderr
+	}
+	printDeviceCode(out, code)
+
+	token, err := adal.WaitForUserCompletion(sender, code)
+	if err != nil {
+		return nil, fmt.Errorf("Could not complete the device code sign in: %v", err)
+	}
+	if err := saveToken(*token); err != nil {
+		return nil, err
+	}
+	spt, err := newToken(*token)
+	if err != nil {
+		return nil, err
+	}
+	return newTokenAuthorizer(newSyncRefresher(spt, tokenRefreshSkew(cfg))), nil
+}
+
+func printDeviceCode(w io.Writer, code *adal.DeviceCode) {
+	if code.Message != nil {
+		fmt.Fprintln(w, *code.Message)
+		return
+	}
+	var userCode, verificationURL string
+	if code.UserCode != nil {
+		userCode = *code.UserCode
+	}
+	if code.VerificationURL != nil {
+		verificationURL = *code.VerificationURL
+	}
+	fmt.Fprintf(w, "To sign in, open %s in a browser and enter the code %s.\n", verificationURL, userCode)
+}
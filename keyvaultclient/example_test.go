@@ -0,0 +1,145 @@
+package keyvaultclient_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// Envelope encryption: the data is encrypted locally with a fresh AES key, which is wrapped with an
+// RSA key that never leaves the vault and stored alongside the ciphertext.
+func ExampleClient_WrapKey() {
+	vault := newExampleVault() // stands in for https://myvault.vault.azure.net
+	defer vault.Close()
+	client, err := keyvaultclient.NewWithTokenProvider(exampleTokenProvider{}, keyvaultclient.Config{HTTPClient: vault.Client()})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	// Unwrapping needs the version the key was wrapped with, so pin it.
+	const keyVersion = "0123456789abcdef0123456789abcdef"
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		log.Fatal(err)
+	}
+	nonce, ciphertext := seal(dek, []byte("attack at dawn"))
+	wrapped, err := client.WrapKey(ctx, vault.URL, "envelope", keyVersion, dek, keyvault.RSAOAEP)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrapped a %d byte key into %d bytes\n", len(dek), len(wrapped))
+
+	// Later, with nonce, ciphertext and wrapped read back from storage:
+	unwrapped, err := client.UnwrapKey(ctx, vault.URL, "envelope", keyVersion, wrapped, keyvault.RSAOAEP)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(open(unwrapped, nonce, ciphertext)))
+	// Output:
+	// wrapped a 32 byte key into 256 bytes
+	// attack at dawn
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, []byte) {
+	gcm := newGCM(key)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		log.Fatal(err)
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil)
+}
+
+func open(key []byte, nonce []byte, ciphertext []byte) []byte {
+	plaintext, err := newGCM(key).Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return plaintext
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return gcm
+}
+
+// exampleTokenProvider authorizes nothing, as the example vault doesn't check tokens.
+type exampleTokenProvider struct{}
+
+func (exampleTokenProvider) Authorizer() (autorest.Authorizer, error) {
+	return autorest.NullAuthorizer{}, nil
+}
+
+// newExampleVault serves wrapkey and unwrapkey with RSA-OAEP, which Key Vault defines with SHA-1, using
+// the RSA key in testdata.
+func newExampleVault() *httptest.Server {
+	data, err := os.ReadFile("testdata/rsa2048.pem")
+	if err != nil {
+		log.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			Alg   string `json:"alg"`
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Alg != string(keyvault.RSAOAEP) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		in, err := base64.RawURLEncoding.DecodeString(params.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var out []byte
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/wrapkey"):
+			out, err = rsa.EncryptOAEP(sha1.New(), rand.Reader, &key.PublicKey, in, nil)
+		case strings.HasSuffix(r.URL.Path, "/unwrapkey"):
+			out, err = rsa.DecryptOAEP(sha1.New(), rand.Reader, key, in, nil)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"kid":   "https://" + r.Host + strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, "/wrapkey"), "/unwrapkey"),
+			"value": base64.RawURLEncoding.EncodeToString(out),
+		})
+	}))
+}
@@ -0,0 +1,70 @@
+package keyvaultclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// VaultTarget is one of the vaults GetSecretWithFallback tries: a vault and the Client that reads from it,
+// so each vault can be in its own cloud or be read with its own credentials.
+type VaultTarget struct {
+	Client       *Client
+	VaultBaseURL string
+}
+
+// FallbackError reports why each vault GetSecretWithFallback tried failed, in the order they were tried.
+// errors.Is matches any of them: when every vault lacks the secret, it is still ErrSecretNotFound.
+type FallbackError struct {
+	Targets []VaultTarget
+	Errors  []error
+}
+
+func (e *FallbackError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = fmt.Sprintf("%s: %v", e.Targets[i].VaultBaseURL, err)
+	}
+	return fmt.Sprintf("could not get the secret from %d vault(s): %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e *FallbackError) Unwrap() []error {
+	return e.Errors
+}
+
+// GetSecretWithFallback reads a secret from the first of targets that has it, as for a primary vault with
+// a secondary in another region. The next vault is only tried when the secret doesn't exist in one, or it
+// couldn't be reached (network failures, timeouts, throttling and 5xx responses, after the usual retries,
+// and requests an open circuit breaker didn't send).
+// Any other error, notably ErrAccessDenied, stops the search at once: it points at a configuration
+// problem that another vault would only hide. Failures are returned as a *FallbackError.
+func GetSecretWithFallback(ctx context.Context, targets []VaultTarget, secretName string, secretVersion string) (Secret, error) {
+	defer timeTrack(time.Now(), "getSecretWithFallback")
+	if len(targets) == 0 {
+		return Secret{}, errors.New("no vaults to read from")
+	}
+
+	fallbackErr := &FallbackError{}
+	for i, target := range targets {
+		secret, err := target.Client.GetSecretDetails(ctx, target.VaultBaseURL, secretName, secretVersion)
+		if err == nil {
+			if i > 0 {
+				log.Infof("Secret read from fallback vault. name=%q vault=%s", secretName, target.VaultBaseURL)
+			}
+			return secret, nil
+		}
+		fallbackErr.Targets = append(fallbackErr.Targets, target)
+		fallbackErr.Errors = append(fallbackErr.Errors, err)
+		if ctx.Err() != nil || !(errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrThrottled) || isRetryable(err)) {
+			return Secret{}, fallbackErr
+		}
+		if i < len(targets)-1 {
+			log.Warnf("Could not get secret %s from %s, trying %s: %v", secretName, target.VaultBaseURL, targets[i+1].VaultBaseURL, err)
+		}
+	}
+	return Secret{}, fallbackErr
+}
@@ -0,0 +1,83 @@
+package keyvaultclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// statusVault answers every request for a secret with status, serving the secret on 200.
+type statusVault struct {
+	status   int
+	requests int32
+}
+
+func (v *statusVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&v.requests, 1)
+	if v.status == http.StatusOK {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"id":    "https://" + r.Host + "/secrets/Password/0123456789abcdef0123456789abcdef",
+			"value": "hunter2",
+		})
+		return
+	}
+	w.Header().Set("Retry-After", "0")
+	writeJSON(w, v.status, map[string]interface{}{
+		"error": map[string]string{"code": http.StatusText(v.status), "message": http.StatusText(v.status)},
+	})
+}
+
+func TestGetSecretWithFallback(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		status      int  // the primary vault's answer
+		openBreaker bool // throttle a request first, so the primary's circuit breaker is open
+		wantErr     error
+		wantSent    int32 // requests the primary vault received, including the one opening the breaker
+	}{
+		{name: "found in primary", status: http.StatusOK, wantSent: 1},
+		{name: "not found in primary", status: http.StatusNotFound, wantSent: 1},
+		{name: "primary throttling", status: http.StatusTooManyRequests, wantSent: 1},
+		{name: "primary circuit breaker open", status: http.StatusTooManyRequests, openBreaker: true, wantSent: 1},
+		{name: "access denied by primary", status: http.StatusForbidden, wantErr: ErrAccessDenied, wantSent: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &statusVault{status: tt.status}
+			primaryServer := httptest.NewTLSServer(primary)
+			defer primaryServer.Close()
+			secondary := &statusVault{status: http.StatusOK}
+			secondaryServer := httptest.NewTLSServer(secondary)
+			defer secondaryServer.Close()
+
+			primaryClient := newStubClient(t, primaryServer, Config{MaxRetries: -1, CircuitBreakerThreshold: 1})
+			ctx := context.Background()
+			if tt.openBreaker {
+				if _, err := primaryClient.GetSecret(ctx, primaryServer.URL, "Password", ""); !errors.Is(err, ErrThrottled) {
+					t.Fatalf("GetSecret from the throttling vault = %v, want ErrThrottled", err)
+				}
+			}
+			targets := []VaultTarget{
+				{Client: primaryClient, VaultBaseURL: primaryServer.URL},
+				{Client: newStubClient(t, secondaryServer, Config{MaxRetries: -1}), VaultBaseURL: secondaryServer.URL},
+			}
+
+			secret, err := GetSecretWithFallback(ctx, targets, "Password", "")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("GetSecretWithFallback error = %v, want one matching %v", err, tt.wantErr)
+				}
+				if n := atomic.LoadInt32(&secondary.requests); n != 0 {
+					t.Errorf("secondary vault received %d request(s), want none", n)
+				}
+			} else if err != nil || string(secret.Value) != "hunter2" {
+				t.Errorf("GetSecretWithFallback = %q, %v; want %q, nil", string(secret.Value), err, "hunter2")
+			}
+			if n := atomic.LoadInt32(&primary.requests); n != tt.wantSent {
+				t.Errorf("primary vault received %d request(s), want %d", n, tt.wantSent)
+			}
+		})
+	}
+}
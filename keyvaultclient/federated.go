@@ -0,0 +1,40 @@
+package keyvaultclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// federatedTokenSecret authenticates a Service Principal with a federated credential: an OIDC token
+// issued by Kubernetes, GitHub Actions or another trusted identity provider, exchanged for an Azure AD
+// token as a client assertion. The file is read again on every refresh, since the issuer rotates it.
+type federatedTokenSecret struct {
+	path string
+}
+
+// SetAuthenticationValues implements adal.ServicePrincipalSecret.
+func (s *federatedTokenSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	assertion, err := readFederatedToken(s.path)
+	if err != nil {
+		return err
+	}
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	v.Set("client_assertion", assertion)
+	return nil
+}
+
+func readFederatedToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Could not read the federated token: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("Could not use the federated token: %s is empty", path)
+	}
+	return token, nil
+}
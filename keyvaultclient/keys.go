@@ -0,0 +1,331 @@
+package keyvaultclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// EncryptWithKey encrypts plaintext with a key held in the vault. An empty keyVersion uses the current
+// version of the key. RSA keys can only encrypt small payloads, so for anything larger encrypt a
+// random data key with this and the data itself locally.
+func (c *Client) EncryptWithKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, plaintext []byte, alg keyvault.JSONWebKeyEncryptionAlgorithm) ([]byte, error) {
+	defer timeTrack(time.Now(), "encryptWithKey")
+	params := keyvault.KeyOperationsParameters{
+		Algorithm: alg,
+		Value:     encodeBase64URL(plaintext),
+	}
+
+	var result keyvault.KeyOperationResult
+	err := c.do(ctx, "Encrypt "+keyName, func(ctx context.Context) (err error) {
+		result, err = c.kv.Encrypt(ctx, vaultBaseURL, keyName, keyVersion, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64URL(result.Result)
+}
+
+// DecryptWithKey decrypts ciphertext produced by EncryptWithKey with the same key, version and algorithm.
+func (c *Client) DecryptWithKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, ciphertext []byte, alg keyvault.JSONWebKeyEncryptionAlgorithm) ([]byte, error) {
+	defer timeTrack(time.Now(), "decryptWithKey")
+	params := keyvault.KeyOperationsParameters{
+		Algorithm: alg,
+		Value:     encodeBase64URL(ciphertext),
+	}
+
+	var result keyvault.KeyOperationResult
+	err := c.do(ctx, "Decrypt "+keyName, func(ctx context.Context) (err error) {
+		result, err = c.kv.Decrypt(ctx, vaultBaseURL, keyName, keyVersion, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64URL(result.Result)
+}
+
+// WrapKey encrypts a data encryption key with a key held in the vault, for envelope encryption: data is
+// encrypted locally with dek, and the wrapped dek returned here is stored alongside the ciphertext. An
+// empty keyVersion uses the current version of the key; record the version you wrapped with, as
+// unwrapping needs the same one.
+func (c *Client) WrapKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, dek []byte, alg keyvault.JSONWebKeyEncryptionAlgorithm) ([]byte, error) {
+	defer timeTrack(time.Now(), "wrapKey")
+	params := keyvault.KeyOperationsParameters{
+		Algorithm: alg,
+		Value:     encodeBase64URL(dek),
+	}
+
+	var result keyvault.KeyOperationResult
+	err := c.do(ctx, "WrapKey "+keyName, func(ctx context.Context) (err error) {
+		result, err = c.kv.WrapKey(ctx, vaultBaseURL, keyName, keyVersion, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64URL(result.Result)
+}
+
+// UnwrapKey recovers a data encryption key wrapped by WrapKey with the same key, version and algorithm.
+func (c *Client) UnwrapKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, wrapped []byte, alg keyvault.JSONWebKeyEncryptionAlgorithm) ([]byte, error) {
+	defer timeTrack(time.Now(), "unwrapKey")
+	params := keyvault.KeyOperationsParameters{
+		Algorithm: alg,
+		Value:     encodeBase64URL(wrapped),
+	}
+
+	var result keyvault.KeyOperationResult
+	err := c.do(ctx, "UnwrapKey "+keyName, func(ctx context.Context) (err error) {
+		result, err = c.kv.UnwrapKey(ctx, vaultBaseURL, keyName, keyVersion, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64URL(result.Result)
+}
+
+// signatureDigestSizes is the digest length, in bytes, each signing algorithm expects. RSNULL signs a
+// caller-formatted digest of any length.
+var signatureDigestSizes = map[keyvault.JSONWebKeySignatureAlgorithm]int{
+	keyvault.PS256:    32,
+	keyvault.RS256:    32,
+	keyvault.ES256:    32,
+	keyvault.ECDSA256: 32,
+	keyvault.PS384:    48,
+	keyvault.RS384:    48,
+	keyvault.ES384:    48,
+	keyvault.PS512:    64,
+	keyvault.RS512:    64,
+	keyvault.ES512:    64,
+	keyvault.RSNULL:   0,
+}
+
+// Sign signs a digest with a key held in the vault and returns the raw signature. The digest must
+// already be hashed to match alg: SHA-256 for PS256, RS256, ES256 and ECDSA256 (P-256K), SHA-384 for
+// PS384, RS384 and ES384, and SHA-512 for PS512, RS512 and ES512. RS and PS algorithms need an RSA
+// key, ES and ECDSA an EC key on the matching curve.
+func (c *Client) Sign(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, digest []byte, alg keyvault.JSONWebKeySignatureAlgorithm) ([]byte, error) {
+	defer timeTrack(time.Now(), "sign")
+	if err := checkDigest(digest, alg); err != nil {
+		return nil, err
+	}
+	params := keyvault.KeySignParameters{
+		Algorithm: alg,
+		Value:     encodeBase64URL(digest),
+	}
+
+	var result keyvault.KeyOperationResult
+	err := c.do(ctx, "Sign "+keyName, func(ctx context.Context) (err error) {
+		result, err = c.kv.Sign(ctx, vaultBaseURL, keyName, keyVersion, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64URL(result.Result)
+}
+
+// Verify reports whether signature is a valid signature of digest by the key, as produced by Sign.
+// An invalid signature is reported as false with a nil error.
+func (c *Client) Verify(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, digest []byte, signature []byte, alg keyvault.JSONWebKeySignatureAlgorithm) (bool, error) {
+	defer timeTrack(time.Now(), "verify")
+	if err := checkDigest(digest, alg); err != nil {
+		return false, err
+	}
+	params := keyvault.KeyVerifyParameters{
+		Algorithm: alg,
+		Digest:    encodeBase64URL(digest),
+		Signature: encodeBase64URL(signature),
+	}
+
+	var result keyvault.KeyVerifyResult
+	err := c.do(ctx, "Verify "+keyName, func(ctx context.Context) (err error) {
+		result, err = c.kv.Verify(ctx, vaultBaseURL, keyName, keyVersion, params)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.Value != nil && *result.Value, nil
+}
+
+// KeyInfo describes a key held in the vault.
+type KeyInfo struct {
+	// ID is the key's identifier, https://<vault>/keys/<name>, with /<version> appended for GetKey.
+	ID      string
+	Name    string
+	Version string
+	// Type is EC, EC-HSM, RSA, RSA-HSM or oct. Key Vault only reports it for a single key, so it is
+	// empty in the results of ListKeys.
+	Type    keyvault.JSONWebKeyType
+	Enabled bool
+	// Managed is true for keys whose lifetime Key Vault manages, such as those backing a certificate.
+	Managed bool
+}
+
+// Key is a key's public material together with its properties.
+type Key struct {
+	KeyInfo
+	// JWK is the public key as Key Vault returns it; the private key never leaves the vault.
+	JWK keyvault.JSONWebKey
+	// PublicKey is JWK as an *rsa.PublicKey or *ecdsa.PublicKey, for verifying signatures offline. It is
+	// nil for symmetric keys and for curves the standard library doesn't implement (P-256K).
+	PublicKey crypto.PublicKey
+}
+
+// ListKeys returns every key in the vault, disabled ones included.
+func (c *Client) ListKeys(ctx context.Context, vaultBaseURL string) ([]KeyInfo, error) {
+	defer timeTrack(time.Now(), "listKeys")
+	var keys []KeyInfo
+	err := c.do(ctx, "ListKeys", func(ctx context.Context) error {
+		keys = nil
+		page, err := c.kv.GetKeys(ctx, vaultBaseURL, nil)
+		if err != nil {
+			return err
+		}
+		for page.NotDone() {
+			for _, item := range page.Values() {
+				if item.Kid == nil {
+					continue
+				}
+				info := KeyInfo{ID: *item.Kid, Name: objectNameFromID(*item.Kid, "keys")}
+				if item.Attributes != nil {
+					info.Enabled = item.Attributes.Enabled != nil && *item.Attributes.Enabled
+				}
+				info.Managed = item.Managed != nil && *item.Managed
+				keys = append(keys, info)
+			}
+			err = nextPage(ctx, page.Next)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetKey returns a key's public material and properties. An empty keyVersion returns the current version.
+func (c *Client) GetKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string) (Key, error) {
+	defer timeTrack(time.Now(), "getKey")
+	var bundle keyvault.KeyBundle
+	err := c.do(ctx, "GetKey "+keyName, func(ctx context.Context) (err error) {
+		bundle, err = c.kv.GetKey(ctx, vaultBaseURL, keyName, keyVersion)
+		return err
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	if bundle.Key == nil {
+		return Key{}, fmt.Errorf("Key Vault returned no key material for %s", keyName)
+	}
+
+	key := Key{JWK: *bundle.Key}
+	if bundle.Key.Kid != nil {
+		key.ID = *bundle.Key.Kid
+		key.Version = objectVersionFromID(key.ID, "keys")
+	}
+	key.Name = objectNameFromID(key.ID, "keys")
+	key.Type = bundle.Key.Kty
+	if bundle.Attributes != nil {
+		key.Enabled = bundle.Attributes.Enabled != nil && *bundle.Attributes.Enabled
+	}
+	key.Managed = bundle.Managed != nil && *bundle.Managed
+	key.PublicKey, err = jwkPublicKey(*bundle.Key)
+	if err != nil {
+		return Key{}, fmt.Errorf("Could not read the public key of %s: %v", keyName, err)
+	}
+	return key, nil
+}
+
+// jwkCurves maps the JWK curve names Go implements onto their curves.
+var jwkCurves = map[keyvault.JSONWebKeyCurveName]elliptic.Curve{
+	keyvault.P256: elliptic.P256(),
+	keyvault.P384: elliptic.P384(),
+	keyvault.P521: elliptic.P521(),
+}
+
+// jwkPublicKey converts the public part of an RSA or EC JWK. It returns nil, without an error, for
+// keys it can't represent.
+func jwkPublicKey(jwk keyvault.JSONWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case keyvault.RSA, keyvault.RSAHSM:
+		n, err := decodeBase64URL(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64URL(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 {
+			return nil, fmt.Errorf("RSA exponent is too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case keyvault.EC, keyvault.ECHSM:
+		curve, ok := jwkCurves[jwk.Crv]
+		if !ok {
+			return nil, nil
+		}
+		x, err := decodeBase64URL(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64URL(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, nil
+}
+
+// checkDigest rejects unknown algorithms and digests of the wrong length for alg before they reach Key Vault.
+func checkDigest(digest []byte, alg keyvault.JSONWebKeySignatureAlgorithm) error {
+	size, ok := signatureDigestSizes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	if len(digest) == 0 {
+		return fmt.Errorf("digest is empty")
+	}
+	if size != 0 && len(digest) != size {
+		return fmt.Errorf("%s needs a %d byte digest, got %d bytes", alg, size, len(digest))
+	}
+	return nil
+}
+
+// encodeBase64URL encodes a payload the way the Key Vault REST API expects: base64url without padding.
+func encodeBase64URL(data []byte) *string {
+	s := base64.RawURLEncoding.EncodeToString(data)
+	return &s
+}
+
+// decodeBase64URL decodes a base64url payload returned by Key Vault, with or without padding.
+func decodeBase64URL(s *string) ([]byte, error) {
+	if s == nil {
+		return nil, fmt.Errorf("Key Vault returned no value")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode the value returned by Key Vault: %v", err)
+	}
+	return data, nil
+}
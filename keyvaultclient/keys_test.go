@@ -0,0 +1,237 @@
+package keyvaultclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// loadTestRSAKey reads the 2048-bit RSA key in testdata, which stands in for a key held in the vault.
+func loadTestRSAKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+	data, err := os.ReadFile("testdata/rsa2048.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatal("testdata/rsa2048.pem holds no PEM block")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// stubKeyVault performs key operations with one RSA key, as Key Vault would for a key named "signing",
+// rejecting requests whose digest or payload isn't unpadded base64url or doesn't suit the algorithm.
+type stubKeyVault struct {
+	t   testing.TB
+	key *rsa.PrivateKey
+
+	mu       sync.Mutex
+	requests []string // the operation and algorithm of each request, e.g. "sign RS256"
+}
+
+// stubSignatureHashes are the hashes of the algorithms stubKeyVault signs with.
+var stubSignatureHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "PS256": crypto.SHA256,
+	"RS384": crypto.SHA384, "PS384": crypto.SHA384,
+	"RS512": crypto.SHA512, "PS512": crypto.SHA512,
+}
+
+func (v *stubKeyVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != http.MethodPost || len(parts) != 4 || parts[0] != "keys" || parts[1] != "signing" {
+		http.NotFound(w, r)
+		return
+	}
+	// Sign sends the digest as value; verify sends it as digest, with the signature as value.
+	var body struct {
+		Alg    string `json:"alg"`
+		Value  string `json:"value"`
+		Digest string `json:"digest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	operation := parts[3]
+	v.mu.Lock()
+	v.requests = append(v.requests, operation+" "+body.Alg)
+	v.mu.Unlock()
+
+	decode := func(s string) []byte {
+		if strings.ContainsAny(s, "=+/") {
+			v.t.Errorf("%s: %q is not unpadded base64url", operation, s)
+		}
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			v.t.Errorf("%s: %v", operation, err)
+		}
+		return data
+	}
+	kid := "https://" + r.Host + "/keys/signing/" + parts[2]
+
+	switch operation {
+	case "sign", "verify":
+		hash, ok := stubSignatureHashes[body.Alg]
+		if !ok {
+			badRequest(w, "Unsupported algorithm "+body.Alg)
+			return
+		}
+		digest := decode(body.Value)
+		if operation == "verify" {
+			digest = decode(body.Digest)
+		}
+		if len(digest) != hash.Size() {
+			badRequest(w, "Invalid digest length")
+			return
+		}
+		pss := strings.HasPrefix(body.Alg, "PS")
+		if operation == "sign" {
+			var signature []byte
+			var err error
+			if pss {
+				signature, err = rsa.SignPSS(rand.Reader, v.key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			} else {
+				signature, err = rsa.SignPKCS1v15(rand.Reader, v.key, hash, digest)
+			}
+			if err != nil {
+				v.t.Errorf("sign: %v", err)
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"kid": kid, "value": base64.RawURLEncoding.EncodeToString(signature)})
+			return
+		}
+		signature := decode(body.Value)
+		var err error
+		if pss {
+			err = rsa.VerifyPSS(&v.key.PublicKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = rsa.VerifyPKCS1v15(&v.key.PublicKey, hash, digest, signature)
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"value": err == nil})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (v *stubKeyVault) requestLog() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.requests...)
+}
+
+func badRequest(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error": map[string]string{"code": "BadParameter", "message": message},
+	})
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key := loadTestRSAKey(t)
+	vault := &stubKeyVault{t: t, key: key}
+	server := httptest.NewTLSServer(vault)
+	defer server.Close()
+	client := newStubClient(t, server, Config{MaxRetries: -1})
+	ctx := context.Background()
+	version := "0123456789abcdef0123456789abcdef"
+
+	sum256 := sha256.Sum256([]byte("message"))
+	sum384 := sha512.Sum384([]byte("message"))
+	sum512 := sha512.Sum512([]byte("message"))
+	for _, tt := range []struct {
+		alg    keyvault.JSONWebKeySignatureAlgorithm
+		hash   crypto.Hash
+		digest []byte
+	}{
+		{keyvault.RS256, crypto.SHA256, sum256[:]},
+		{keyvault.RS384, crypto.SHA384, sum384[:]},
+		{keyvault.RS512, crypto.SHA512, sum512[:]},
+		{keyvault.PS256, crypto.SHA256, sum256[:]},
+		{keyvault.PS512, crypto.SHA512, sum512[:]},
+	} {
+		t.Run(string(tt.alg), func(t *testing.T) {
+			signature, err := client.Sign(ctx, server.URL, "signing", version, tt.digest, tt.alg)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if strings.HasPrefix(string(tt.alg), "PS") {
+				err = rsa.VerifyPSS(&key.PublicKey, tt.hash, tt.digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			} else {
+				err = rsa.VerifyPKCS1v15(&key.PublicKey, tt.hash, tt.digest, signature)
+			}
+			if err != nil {
+				t.Errorf("signature doesn't verify with the public key: %v", err)
+			}
+
+			ok, err := client.Verify(ctx, server.URL, "signing", version, tt.digest, signature, tt.alg)
+			if err != nil || !ok {
+				t.Errorf("Verify = %v, %v; want true, nil", ok, err)
+			}
+			signature[0] ^= 0xff
+			ok, err = client.Verify(ctx, server.URL, "signing", version, tt.digest, signature, tt.alg)
+			if err != nil || ok {
+				t.Errorf("Verify of an altered signature = %v, %v; want false, nil", ok, err)
+			}
+		})
+	}
+
+	want := []string{}
+	for _, alg := range []string{"RS256", "RS384", "RS512", "PS256", "PS512"} {
+		want = append(want, "sign "+alg, "verify "+alg, "verify "+alg)
+	}
+	if got := vault.requestLog(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestSignRejectsBadDigests(t *testing.T) {
+	vault := &stubKeyVault{t: t, key: loadTestRSAKey(t)}
+	server := httptest.NewTLSServer(vault)
+	defer server.Close()
+	client := newStubClient(t, server, Config{MaxRetries: -1})
+	ctx := context.Background()
+
+	sum256 := sha256.Sum256([]byte("message"))
+	sum512 := sha512.Sum512([]byte("message"))
+	for _, tt := range []struct {
+		name   string
+		alg    keyvault.JSONWebKeySignatureAlgorithm
+		digest []byte
+	}{
+		{"SHA-512 digest for RS256", keyvault.RS256, sum512[:]},
+		{"SHA-256 digest for PS384", keyvault.PS384, sum256[:]},
+		{"unhashed message", keyvault.RS256, []byte("message")},
+		{"empty digest", keyvault.RS256, nil},
+		{"unknown algorithm", "RS1", sum256[:]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.Sign(ctx, server.URL, "signing", "", tt.digest, tt.alg); err == nil {
+				t.Error("Sign succeeded, want an error")
+			}
+			if _, err := client.Verify(ctx, server.URL, "signing", "", tt.digest, []byte("signature"), tt.alg); err == nil {
+				t.Error("Verify succeeded, want an error")
+			}
+		})
+	}
+	if got := vault.requestLog(); len(got) != 0 {
+		t.Errorf("bad digests reached Key Vault: %v", got)
+	}
+}
@@ -0,0 +1,69 @@
+package keyvaultclient
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// managedHSMDNSSuffixes are the domains of Managed HSM pools in each cloud that has them, by
+// azure.Environment name. Tokens for a pool are requested for https://<suffix>.
+var managedHSMDNSSuffixes = map[string]string{
+	azure.PublicCloud.Name:       "managedhsm.azure.net",
+	azure.USGovernmentCloud.Name: "managedhsm.usgovcloudapi.net",
+	azure.ChinaCloud.Name:        "managedhsm.azure.cn",
+}
+
+// managedHSMAPIVersion is the Key Vault API version sent to Managed HSM, which rejects the older one the
+// pinned SDK uses. The key operations it was asked for have the same requests and responses in both.
+const managedHSMAPIVersion = "7.2"
+
+// ManagedHSMDNSSuffix returns the domain of Managed HSM pools in env, e.g. managedhsm.azure.net, or ""
+// when the cloud has none.
+func ManagedHSMDNSSuffix(env azure.Environment) string {
+	return managedHSMDNSSuffixes[env.Name]
+}
+
+// isManagedHSMHost reports whether host is a Managed HSM pool in env.
+func isManagedHSMHost(env azure.Environment, host string) bool {
+	suffix := ManagedHSMDNSSuffix(env)
+	return suffix != "" && strings.HasSuffix(host, "."+suffix)
+}
+
+// withManagedHSM readies requests for Managed HSM pools in env, leaving those for vaults as they are.
+// Pools hold only keys, so requests for secrets and certificates fail with ErrManagedHSMUnsupported
+// rather than being sent.
+func withManagedHSM(env azure.Environment) autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil || !isManagedHSMHost(env, r.URL.Hostname()) {
+				return r, err
+			}
+			if err := checkManagedHSMRequest(env, r); err != nil {
+				return r, err
+			}
+			q := r.URL.Query()
+			q.Set("api-version", managedHSMAPIVersion)
+			r.URL.RawQuery = q.Encode()
+			return r, nil
+		})
+	}
+}
+
+// checkManagedHSMRequest returns ErrManagedHSMUnsupported for a request for secrets or certificates to a
+// Managed HSM pool in env.
+func checkManagedHSMRequest(env azure.Environment, r *http.Request) error {
+	if !isManagedHSMHost(env, r.URL.Hostname()) {
+		return nil
+	}
+	for _, prefix := range []string{"/secrets", "/deletedsecrets", "/certificates", "/deletedcertificates"} {
+		if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+			return fmt.Errorf("%s is a Managed HSM pool: %w", r.URL.Hostname(), ErrManagedHSMUnsupported)
+		}
+	}
+	return nil
+}
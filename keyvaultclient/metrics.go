@@ -0,0 +1,66 @@
+package keyvaultclient
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// operationDuration records the latency of every operation timed by timeTrack, such as getSecret or
+// NewServicePrincipalToken. It is only observed once EnableMetrics has been called.
+var operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "goazurekeyvault",
+	Name:      "operation_duration_seconds",
+	Help:      "Time taken by Key Vault and Azure AD operations.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"operation"})
+
+// tokenCacheLookups counts reads of the on-disk token cache by result: hit when the cached token could be
+// used as is, missing when there was none (or it couldn't be read), and expired when it was within the
+// refresh skew of expiry. Every result other than hit costs a round trip to Azure AD.
+var tokenCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "goazurekeyvault",
+	Name:      "token_cache_lookups_total",
+	Help:      "Token cache lookups by result (hit, missing, expired).",
+}, []string{"result"})
+
+var metricsEnabled bool
+
+// EnableMetrics registers the operation latency histogram and token cache counters with reg and starts
+// recording into them. Call it once, before creating any Client.
+func EnableMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{operationDuration, tokenCacheLookups} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	metricsEnabled = true
+	return nil
+}
+
+// observeTokenCacheLookup counts a token cache lookup that loaded token, nil when there was none, as a
+// hit if it is usable for longer than skew, for metrics and TokenCacheSummary.
+func observeTokenCacheLookup(token *adal.Token, skew time.Duration) {
+	result := "hit"
+	switch {
+	case token == nil:
+		result = "missing"
+	case token.WillExpireIn(skew):
+		result = "expired"
+	}
+	log.Debugf("Token cache lookup. result=%s", result)
+	recordTokenCacheLookup(result)
+	if metricsEnabled {
+		tokenCacheLookups.WithLabelValues(result).Inc()
+	}
+}
+
+// observeDuration records elapsed for operation, if metrics are enabled.
+func observeDuration(operation string, elapsed time.Duration) {
+	if metricsEnabled {
+		operationDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+	}
+}
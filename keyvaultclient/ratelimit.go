@@ -0,0 +1,46 @@
+package keyvaultclient
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// rateLimitSender holds every request to Key Vault, including pages of lists and retries, to
+// Config.RequestsPerSecond. Concurrent requests share the limit, so the GetSecrets workers wait their
+// turn and MaxConcurrency only bounds how many are in flight at once.
+type rateLimitSender struct {
+	sender  autorest.Sender
+	limiter *rate.Limiter
+}
+
+// newRateLimitSender wraps sender, allowing a burst of one second's worth of requests. It returns sender
+// unchanged when requestsPerSecond is zero.
+func newRateLimitSender(sender autorest.Sender, requestsPerSecond float64) autorest.Sender {
+	if requestsPerSecond <= 0 {
+		return sender
+	}
+	burst := int(math.Ceil(requestsPerSecond))
+	return rateLimitSender{sender: sender, limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst)}
+}
+
+func (s rateLimitSender) Do(r *http.Request) (*http.Response, error) {
+	reservation := s.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		log.Debugf("Rate limit reached, waiting. delay=%s url=%s", delay, r.URL.Path)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			reservation.Cancel()
+			return nil, r.Context().Err()
+		}
+	}
+	return s.sender.Do(r)
+}
@@ -0,0 +1,61 @@
+package keyvaultclient
+
+import (
+	"fmt"
+	"io"
+)
+
+const redacted = "***REDACTED***"
+
+// RedactedString holds a secret value that prints as ***REDACTED*** with every fmt verb and in JSON and YAML,
+// so a Secret that ends up in a log message doesn't leak it. Convert it with string(v) to use the value.
+type RedactedString string
+
+func (s RedactedString) String() string {
+	return redacted
+}
+
+// Format implements fmt.Formatter, covering %q, %x and %#v as well as %s and %v.
+func (s RedactedString) Format(f fmt.State, verb rune) {
+	io.WriteString(f, redacted)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s RedactedString) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// MarshalYAML implements yaml.Marshaler, which yaml.v2 consults instead of String.
+func (s RedactedString) MarshalYAML() (interface{}, error) {
+	return redacted, nil
+}
+
+// SecretBytes is a secret value in a buffer that can be wiped with Zero once it has been used. Like
+// RedactedString it prints and marshals as ***REDACTED***.
+type SecretBytes []byte
+
+// Zero overwrites the value with zeros.
+func (b SecretBytes) Zero() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func (b SecretBytes) String() string {
+	return redacted
+}
+
+// Format implements fmt.Formatter.
+func (b SecretBytes) Format(f fmt.State, verb rune) {
+	io.WriteString(f, redacted)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (b SecretBytes) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (b SecretBytes) MarshalYAML() (interface{}, error) {
+	return redacted, nil
+}
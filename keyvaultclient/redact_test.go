@@ -0,0 +1,55 @@
+package keyvaultclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestRedactedValuesNeverAppear(t *testing.T) {
+	const plaintext = "hunter2-plaintext"
+	secret := Secret{Name: "Password", Value: RedactedString(plaintext), Version: "v1"}
+	values := map[string]interface{}{
+		"RedactedString":  RedactedString(plaintext),
+		"*RedactedString": &secret.Value,
+		"SecretBytes":     SecretBytes(plaintext),
+		"Secret":          secret,
+		"*Secret":         &secret,
+		"[]Secret":        []Secret{secret},
+		"map of Secrets":  map[string]Secret{"Password": secret},
+	}
+
+	for name, v := range values {
+		outputs := map[string]func() (string, error){
+			"json": func() (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+			"yaml": func() (string, error) {
+				b, err := yaml.Marshal(v)
+				return string(b), err
+			},
+		}
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x"} {
+			verb := verb
+			outputs[verb] = func() (string, error) { return fmt.Sprintf(verb, v), nil }
+		}
+
+		for format, output := range outputs {
+			got, err := output()
+			if err != nil {
+				t.Errorf("%s of %s: %v", format, name, err)
+				continue
+			}
+			if strings.Contains(got, plaintext) || strings.Contains(got, fmt.Sprintf("%x", plaintext)) {
+				t.Errorf("%s of %s reveals the value: %s", format, name, got)
+			}
+			if !strings.Contains(got, redacted) {
+				t.Errorf("%s of %s = %s, want it to show %s", format, name, got, redacted)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package keyvaultclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// ParseKeyVaultReference reads an App Service style Key Vault reference, in either of its forms:
+//
+//	@Microsoft.KeyVault(SecretUri=https://myvault.vault.azure.net/secrets/Password/<version>)
+//	@Microsoft.KeyVault(VaultName=myvault;SecretName=Password;SecretVersion=<version>)
+//
+// The version is optional in both. A vault given by name is looked up in env's Key Vault domain.
+func ParseKeyVaultReference(ref string, env azure.Environment) (SecretRef, error) {
+	const prefix, suffix = "@Microsoft.KeyVault(", ")"
+	trimmed := strings.TrimSpace(ref)
+	if !strings.HasPrefix(trimmed, prefix) || !strings.HasSuffix(trimmed, suffix) {
+		return SecretRef{}, fmt.Errorf("%q is not of the form @Microsoft.KeyVault(...)", ref)
+	}
+
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(trimmed, prefix), suffix), ";") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		i := strings.Index(param, "=")
+		if i <= 0 {
+			return SecretRef{}, fmt.Errorf("%q in Key Vault reference is not Name=value", param)
+		}
+		key, value := strings.TrimSpace(param[:i]), strings.TrimSpace(param[i+1:])
+		switch key {
+		case "SecretUri", "VaultName", "SecretName", "SecretVersion":
+		default:
+			return SecretRef{}, fmt.Errorf("unknown parameter %q in Key Vault reference", key)
+		}
+		if _, ok := params[key]; ok {
+			return SecretRef{}, fmt.Errorf("parameter %q is given twice in Key Vault reference", key)
+		}
+		params[key] = value
+	}
+
+	if uri, ok := params["SecretUri"]; ok {
+		for _, key := range []string{"VaultName", "SecretName", "SecretVersion"} {
+			if _, ok := params[key]; ok {
+				return SecretRef{}, fmt.Errorf("parameter %q can't be combined with SecretUri in Key Vault reference", key)
+			}
+		}
+		return secretRefFromURI(uri)
+	}
+	vault, name := params["VaultName"], params["SecretName"]
+	switch {
+	case vault == "":
+		return SecretRef{}, fmt.Errorf("Key Vault reference %q needs SecretUri, or VaultName and SecretName", ref)
+	case name == "":
+		return SecretRef{}, fmt.Errorf("Key Vault reference %q has VaultName but no SecretName", ref)
+	case strings.ContainsAny(vault, "./:"):
+		return SecretRef{}, fmt.Errorf("VaultName %q in Key Vault reference is not a vault name", vault)
+	}
+	return SecretRef{
+		VaultBaseURL: "https://" + vault + "." + env.KeyVaultDNSSuffix,
+		Name:         name,
+		Version:      params["SecretVersion"],
+	}, nil
+}
+
+// ResolveKeyVaultReference reads the secret an App Service style Key Vault reference points at, so the
+// reference strings already in App Service settings can be used as they are. See ParseKeyVaultReference.
+func (c *Client) ResolveKeyVaultReference(ctx context.Context, ref string) (Secret, error) {
+	secretRef, err := ParseKeyVaultReference(ref, c.env)
+	if err != nil {
+		return Secret{}, err
+	}
+	return c.GetSecretDetails(ctx, secretRef.VaultBaseURL, secretRef.Name, secretRef.Version)
+}
+
+// secretRefFromURI splits a secret identifier, https://myvault.vault.azure.net/secrets/Name optionally
+// followed by the version, into a SecretRef.
+func secretRefFromURI(uri string) (SecretRef, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return SecretRef{}, fmt.Errorf("secret identifier %q is not an https URL", uri)
+	}
+	name := SecretNameFromID(uri)
+	if name == uri {
+		return SecretRef{}, fmt.Errorf("%q is not a secret identifier", uri)
+	}
+	return SecretRef{
+		VaultBaseURL: u.Scheme + "://" + u.Host,
+		Name:         name,
+		Version:      SecretVersionFromID(uri),
+	}, nil
+}
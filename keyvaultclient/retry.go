@@ -0,0 +1,211 @@
+package keyvaultclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// DefaultMaxRetries is the number of times a failed operation is retried when Config.MaxRetries is zero.
+const DefaultMaxRetries = 3
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// retryableStatusCodes are the responses Key Vault sends for throttling and transient failures.
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// throttleSender hands 429 responses straight back to the SDK caller. The retry decorator in
+// autorest v10 retries 429s indefinitely without counting them as attempts, so MaxRetries could
+// never apply; it does return immediately on errors implementing adal.TokenRefreshError, which
+// throttledError does, leaving the decision to Client.do. Each response's Date is also checked for
+// clock skew.
+type throttleSender struct {
+	sender autorest.Sender
+}
+
+func (s throttleSender) Do(r *http.Request) (*http.Response, error) {
+	resp, err := s.sender.Do(r)
+	if err == nil {
+		checkClockSkew(resp)
+	}
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		// Only the headers are needed to honor Retry-After.
+		resp.Body.Close()
+		return resp, throttledError{resp: resp}
+	}
+	return resp, err
+}
+
+// throttledError reports a 429 Too Many Requests response from Key Vault.
+type throttledError struct {
+	resp *http.Response
+}
+
+func (e throttledError) Error() string {
+	return "keyvault: request throttled (429 Too Many Requests)"
+}
+
+// Response implements adal.TokenRefreshError.
+func (e throttledError) Response() *http.Response {
+	return e.resp
+}
+
+// maxRetries returns Config.MaxRetries, or DefaultMaxRetries when it is zero.
+func maxRetries(cfg Config) int {
+	switch {
+	case cfg.MaxRetries == 0:
+		return DefaultMaxRetries
+	case cfg.MaxRetries < 0:
+		return 0
+	}
+	return cfg.MaxRetries
+}
+
+// do runs a single Key Vault operation, bounding each attempt by Config.RequestTimeout and
+// retrying transient failures up to Config.MaxRetries times, all within Config.OperationDeadline. The
+// final error goes through classifyError.
+func (c *Client) do(ctx context.Context, operation string, call func(ctx context.Context) error) error {
+	budgetCtx := ctx
+	if c.deadline > 0 {
+		var cancel context.CancelFunc
+		budgetCtx, cancel = context.WithTimeout(ctx, c.deadline)
+		defer cancel()
+	}
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := c.withTimeout(budgetCtx)
+		err := timeoutError(attemptCtx, operation, call(attemptCtx))
+		cancel()
+		if err != nil && ctx.Err() == nil && budgetCtx.Err() != nil {
+			return deadlineError(operation, c.deadline, attempt+1, err)
+		}
+		if err == nil || attempt >= c.maxRetries || ctx.Err() != nil || !isRetryable(err) {
+			return classifyError(err)
+		}
+
+		delay := retryDelay(err, attempt)
+		log.Debugf("Retrying %s. attempt=%d delay=%s error=%v", operation, attempt+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-budgetCtx.Done():
+			if ctx.Err() == nil {
+				return deadlineError(operation, c.deadline, attempt+1, err)
+			}
+			return classifyError(err)
+		}
+	}
+}
+
+// deadlineError reports an operation that used up Config.OperationDeadline, along with the error of its
+// last attempt. It matches context.DeadlineExceeded.
+func deadlineError(operation string, deadline time.Duration, attempts int, last error) error {
+	return fmt.Errorf("%s gave up after %d attempt(s) when its %s operation deadline ran out (last error: %v): %w",
+		operation, attempts, deadline, last, context.DeadlineExceeded)
+}
+
+// isRetryable reports whether err is throttling, a transient server or network failure, or an attempt
+// that timed out. Everything else, notably 401, 403 and 404, fails immediately.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if retryableStatusCodes[statusCode(err)] {
+		return true
+	}
+	if de, ok := err.(autorest.DetailedError); ok {
+		var netErr net.Error
+		return errors.As(de.Original, &netErr)
+	}
+	return false
+}
+
+// refreshWithRetry runs a token refresh, retrying it up to maxRetries times with the same backoff as
+// Client.do when Azure AD couldn't be reached, throttled the request or failed with a server error.
+// Azure AD rejecting the request, as with invalid_client for a wrong client secret, fails immediately.
+func refreshWithRetry(refresh func() error, maxRetries int) error {
+	for attempt := 0; ; attempt++ {
+		err := refresh()
+		if err == nil || attempt >= maxRetries || !isRetryableRefresh(err) {
+			return err
+		}
+		delay := retryDelay(err, attempt)
+		log.Debugf("Retrying token refresh. attempt=%d delay=%s error=%v", attempt+1, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// isRetryableRefresh reports whether a failed adal refresh is worth retrying. adal returns the response
+// for every failure Azure AD answered, but reports a request that got no response with a plain error
+// holding only the cause's text.
+func isRetryableRefresh(err error) bool {
+	var refreshErr adal.TokenRefreshError
+	if errors.As(err, &refreshErr) {
+		return refreshErr.Response() != nil && retryableStatusCodes[refreshErr.Response().StatusCode]
+	}
+	return strings.Contains(err.Error(), "Failed to execute the refresh request")
+}
+
+// refreshError classifies a token refresh that ultimately failed: Azure AD answering with a client error
+// means the credentials were rejected.
+func refreshError(err error) error {
+	var refreshErr adal.TokenRefreshError
+	if errors.As(err, &refreshErr) && refreshErr.Response() != nil && !isRetryableRefresh(err) {
+		return statusError{sentinel: ErrAuthentication, err: fmt.Errorf("Could not get a token from Azure AD: %v", err)}
+	}
+	return fmt.Errorf("Could not get a token from Azure AD: %v", err)
+}
+
+// retryDelay honors the Retry-After header Key Vault sends with 429 responses, and otherwise
+// backs off exponentially from retryBaseDelay with jitter.
+func retryDelay(err error, attempt int) time.Duration {
+	if de, ok := err.(autorest.DetailedError); ok && de.Response != nil && de.Response.StatusCode == http.StatusTooManyRequests {
+		if d, ok := retryAfter(de.Response.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+
+	d := retryBaseDelay << uint(attempt)
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	// Pick uniformly from [d/2, d) so concurrent clients don't retry in lockstep.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
@@ -0,0 +1,95 @@
+package keyvaultclient
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// DefaultPasswordCharset is the character set PasswordGenerator uses when given an empty one.
+const DefaultPasswordCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#%*+-=?@^_~"
+
+// PasswordGenerator returns a generator for RotateSecret producing passwords of length characters drawn
+// uniformly from charset, or DefaultPasswordCharset when charset is empty, using crypto/rand.
+func PasswordGenerator(length int, charset string) func() string {
+	if charset == "" {
+		charset = DefaultPasswordCharset
+	}
+	chars := []rune(charset)
+	max := big.NewInt(int64(len(chars)))
+	return func() string {
+		password := make([]rune, length)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				// There is no safe fallback for the system's random source failing.
+				panic(fmt.Sprintf("crypto/rand failed: %v", err))
+			}
+			password[i] = chars[n.Int64()]
+		}
+		return string(password)
+	}
+}
+
+// RotateOptions controls RotateSecret.
+type RotateOptions struct {
+	// DisablePrevious disables the version that was current before the rotation, so it can no longer be read.
+	DisablePrevious bool
+}
+
+// RotateSecret creates a new version of a secret with a value from gen, carrying over the content type and
+// tags of the current version. It returns the new version and the one it replaced, which is empty when
+// the secret didn't exist yet. If the new version was written but disabling the previous one failed, both
+// versions are returned along with the error.
+func (c *Client) RotateSecret(ctx context.Context, vaultBaseURL string, secretName string, gen func() string, opts RotateOptions) (string, string, error) {
+	defer timeTrack(time.Now(), "rotateSecret")
+	// Read straight from the vault: a cached copy may be out of date, and rotating a secret because it
+	// has expired must work with Config.StrictExpiry set.
+	var current keyvault.SecretBundle
+	err := c.do(ctx, "GetSecret "+secretName, func(ctx context.Context) (err error) {
+		current, err = c.kv.GetSecret(ctx, vaultBaseURL, secretName, "")
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return "", "", err
+	}
+
+	var previous string
+	var setOpts SecretOptions
+	if err == nil {
+		if current.ID != nil {
+			previous = SecretVersionFromID(*current.ID)
+		}
+		if current.ContentType != nil {
+			setOpts.ContentType = *current.ContentType
+		}
+		setOpts.Tags = make(map[string]string, len(current.Tags))
+		for k, v := range current.Tags {
+			if v != nil {
+				setOpts.Tags[k] = *v
+			}
+		}
+	}
+
+	version, err := c.SetSecret(ctx, vaultBaseURL, secretName, gen(), setOpts)
+	if err != nil {
+		return "", previous, err
+	}
+	log.Debugf("Rotated secret. name=%q version=%s previous=%s", secretName, version, previous)
+
+	if opts.DisablePrevious && previous != "" {
+		enabled := false
+		_, err = c.UpdateSecretAttributes(ctx, vaultBaseURL, secretName, previous, SecretUpdate{Enabled: &enabled})
+		if err != nil {
+			return version, previous, fmt.Errorf("Could not disable previous version %s of %s: %w", previous, secretName, err)
+		}
+	}
+	return version, previous, nil
+}
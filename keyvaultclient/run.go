@@ -0,0 +1,75 @@
+package keyvaultclient
+
+import (
+	"context"
+	"time"
+)
+
+// RunConfig is what Run fetches, and the Config of the Client it fetches with.
+type RunConfig struct {
+	Config
+	// Secrets are the secrets to fetch, each from its own vault and at its own version.
+	Secrets []SecretRef
+	// TokenProvider, when set, authorizes the Client instead of Config's credentials, as with
+	// NewWithTokenProvider.
+	TokenProvider TokenProvider
+}
+
+// Result holds what Run fetched.
+type Result struct {
+	// Secrets holds the value of each secret fetched, keyed by name. When two SecretRefs name the same
+	// secret, say in different vaults, the later one's is kept; Details has both.
+	Secrets map[string]string
+	// Versions holds the version each secret resolved to, keyed by name like Secrets.
+	Versions map[string]string
+	// Details holds each fetched Secret with its properties, in the order of RunConfig.Secrets. Those that
+	// couldn't be fetched are left out.
+	Details []Secret
+	// Duration is how long the run took, authentication included.
+	Duration time.Duration
+	// Timings summarizes the operations recorded since EnableTimings. It is nil unless timings are enabled.
+	Timings []OperationTiming
+	// TokenCache counts the token cache lookups recorded since EnableTimings. It is zero unless timings
+	// are enabled.
+	TokenCache TokenCacheLookups
+}
+
+// Run creates a Client from cfg and fetches cfg.Secrets concurrently, as GetSecretRefs does. It is the
+// fetch the command line tool makes, for programs that want its result rather than its output. An error
+// creating the Client is returned with a nil Result. If some secrets couldn't be fetched, the Result holds
+// the others and the error joins a *SecretError for each failure, as SecretsError.Join makes.
+func Run(ctx context.Context, cfg RunConfig) (*Result, error) {
+	start := time.Now()
+	tp := cfg.TokenProvider
+	if tp == nil {
+		tp = &ConfigTokenProvider{Config: cfg.Config, ctx: ctx}
+	}
+	client, err := NewWithTokenProvider(tp, cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched, err := client.GetSecretRefs(ctx, cfg.Secrets)
+	result := &Result{
+		Secrets:  make(map[string]string, len(fetched)),
+		Versions: make(map[string]string, len(fetched)),
+	}
+	for _, ref := range cfg.Secrets {
+		secret, ok := fetched[ref]
+		if !ok {
+			continue
+		}
+		result.Secrets[secret.Name] = string(secret.Value)
+		result.Versions[secret.Name] = secret.Version
+		result.Details = append(result.Details, secret)
+	}
+	result.Duration = time.Since(start)
+	if timingsEnabled() {
+		result.Timings = TimingSummary()
+		result.TokenCache = TokenCacheSummary()
+	}
+	if failed, ok := err.(SecretsError); ok {
+		return result, failed.Join()
+	}
+	return result, err
+}
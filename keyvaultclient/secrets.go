@@ -0,0 +1,999 @@
+package keyvaultclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+)
+
+// Errors returned by Client methods can be tested with errors.Is. The SDK's autorest.DetailedError stays
+// available through errors.As.
+var (
+	// ErrSecretNotFound is returned when the named secret, or the key or certificate an operation names,
+	// does not exist (404).
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrAccessDenied is returned when the caller may not perform the operation (403), usually because the
+	// vault's access policy doesn't grant the permission.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrThrottled is returned when Key Vault kept throttling requests (429) after every retry.
+	ErrThrottled = errors.New("request throttled")
+	// ErrCircuitOpen is returned, with Config.CircuitBreakerThreshold set, for requests not sent because
+	// the vault throttled too many in a row. It also matches ErrThrottled.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrAuthentication is returned when no token could be obtained or refreshed, including by New, or
+	// Key Vault rejected the token (401).
+	ErrAuthentication = errors.New("authentication failed")
+	// ErrSoftDeleteNotEnabled is returned by recover and purge on vaults without soft-delete.
+	ErrSoftDeleteNotEnabled = errors.New("soft-delete is not enabled on this vault")
+	// ErrInvalidBackup is returned by RestoreSecret for data that isn't a secret backup from BackupSecret.
+	ErrInvalidBackup = errors.New("not a Key Vault secret backup")
+	// ErrNotExportable is returned by GetCertificateAsPFX for a certificate whose policy doesn't allow its
+	// private key to be exported.
+	ErrNotExportable = errors.New("certificate's private key is not exportable")
+	// ErrManagedHSMUnsupported is returned for secret and certificate operations on a Managed HSM pool,
+	// which holds only keys.
+	ErrManagedHSMUnsupported = errors.New("Managed HSM supports only key operations, not secrets or certificates")
+	// ErrSecretExpired is returned, with Config.StrictExpiry set, for a secret read after its Expires time.
+	ErrSecretExpired = errors.New("secret has expired")
+	// ErrSecretNotYetActive is returned, with Config.StrictExpiry set, for a secret read before its NotBefore time.
+	ErrSecretNotYetActive = errors.New("secret is not yet active")
+)
+
+// secretBackupHeader starts every secret backup blob Key Vault produces.
+var secretBackupHeader = []byte("&AzureKeyVaultSecretBackup")
+
+// SecretOptions holds the optional properties that can be recorded on a secret when it is set.
+type SecretOptions struct {
+	ContentType string
+	Tags        map[string]string
+	NotBefore   *time.Time
+	Expires     *time.Time
+}
+
+// Secret is a secret's value together with the properties Key Vault records alongside it.
+type Secret struct {
+	Name string
+	// Value is redacted when printed or marshaled; string(secret.Value) is the plaintext.
+	Value       RedactedString
+	Version     string
+	ContentType string
+	Updated     time.Time
+	// NotBefore and Expires bound when the secret is meant to be used. They are zero when not set.
+	NotBefore time.Time
+	Expires   time.Time
+}
+
+// GetSecret returns the value of a secret. An empty secretVersion returns the current (latest) version.
+func (c *Client) GetSecret(ctx context.Context, vaultBaseURL string, secretName string, secretVersion string) (string, error) {
+	secret, err := c.GetSecretDetails(ctx, vaultBaseURL, secretName, secretVersion)
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Value), nil
+}
+
+// GetSecretBytes is GetSecret, returning the value in a buffer the caller can wipe once it is done with it:
+//
+//	value, err := client.GetSecretBytes(ctx, vaultBaseURL, "Password", "")
+//	if err != nil {
+//		return err
+//	}
+//	defer value.Zero()
+//
+// This narrows how long the value sits in memory rather than guaranteeing it is gone: the SDK decodes the
+// response into a string first, which can't be wiped and lives until the garbage collector reclaims it.
+func (c *Client) GetSecretBytes(ctx context.Context, vaultBaseURL string, secretName string, secretVersion string) (SecretBytes, error) {
+	secret, err := c.GetSecretDetails(ctx, vaultBaseURL, secretName, secretVersion)
+	if err != nil {
+		return nil, err
+	}
+	return SecretBytes(secret.Value), nil
+}
+
+// GetSecretDetails is GetSecret, additionally returning the secret's version, content type and last update time.
+func (c *Client) GetSecretDetails(ctx context.Context, vaultBaseURL string, secretName string, secretVersion string) (Secret, error) {
+	ref := SecretRef{VaultBaseURL: vaultBaseURL, Name: secretName, Version: secretVersion}
+	if secret, ok := c.cache.get(ref); ok {
+		log.Debugf("Secret served from cache. name=%q", secretName)
+		err := c.checkActive(secret)
+		c.audit.secretRead(ref, secret.Version, true, err)
+		return secret, err
+	}
+
+	defer timeTrack(time.Now(), "getSecret")
+	ctx, span := startVaultSpan(ctx, "GetSecret", vaultBaseURL,
+		attribute.String("keyvault.secret.name", secretName),
+		attribute.String("keyvault.secret.version", secretVersion))
+	var secretBundle keyvault.SecretBundle
+	err := c.do(ctx, "GetSecret "+secretName, func(ctx context.Context) (err error) {
+		secretBundle, err = c.secrets.GetSecret(ctx, vaultBaseURL, secretName, secretVersion)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		c.audit.secretRead(ref, "", false, err)
+		return Secret{}, err
+	}
+
+	secret := Secret{Name: secretName}
+	if secretBundle.Value != nil {
+		secret.Value = RedactedString(*secretBundle.Value)
+	}
+	if secretBundle.ID != nil {
+		secret.Version = SecretVersionFromID(*secretBundle.ID)
+	}
+	if secretBundle.ContentType != nil {
+		secret.ContentType = *secretBundle.ContentType
+	}
+	if secretBundle.Attributes != nil {
+		secret.Updated = unixTime(secretBundle.Attributes.Updated)
+		secret.NotBefore = unixTime(secretBundle.Attributes.NotBefore)
+		secret.Expires = unixTime(secretBundle.Attributes.Expires)
+	}
+	c.cache.put(ref, secret)
+	err = c.checkActive(secret)
+	c.audit.secretRead(ref, secret.Version, false, err)
+	return secret, err
+}
+
+// checkActive warns about a secret read outside its NotBefore and Expires times, which usually means an
+// old version was pinned or a rotation was missed. With Config.StrictExpiry it returns an error instead.
+func (c *Client) checkActive(secret Secret) error {
+	now := time.Now()
+	var err error
+	switch {
+	case !secret.Expires.IsZero() && now.After(secret.Expires):
+		err = fmt.Errorf("%w: %s version %s expired at %s", ErrSecretExpired, secret.Name, secret.Version, secret.Expires.Format(time.RFC3339))
+	case !secret.NotBefore.IsZero() && now.Before(secret.NotBefore):
+		err = fmt.Errorf("%w: %s version %s is not active until %s", ErrSecretNotYetActive, secret.Name, secret.Version, secret.NotBefore.Format(time.RFC3339))
+	default:
+		return nil
+	}
+	if c.strictExpiry {
+		return err
+	}
+	log.Warn(err)
+	return nil
+}
+
+// SecretInfo is a secret version's metadata, without its value.
+type SecretInfo struct {
+	ID          string
+	Name        string
+	Version     string
+	ContentType string
+	Tags        map[string]string
+	Enabled     bool
+	// Created, Updated, NotBefore and Expires are zero when Key Vault doesn't report them.
+	Created   time.Time
+	Updated   time.Time
+	NotBefore time.Time
+	Expires   time.Time
+	// RecoveryLevel says whether a deleted secret can be recovered, e.g. "Recoverable+Purgeable".
+	RecoveryLevel string
+	// Managed is true for secrets backing a certificate.
+	Managed bool
+}
+
+// GetSecretInfo returns the metadata of a secret version, empty meaning the current one. Key Vault has
+// no way to read a secret without its value, so the value is fetched and dropped; the read counts as a
+// secret access in the audit log, but isn't cached.
+func (c *Client) GetSecretInfo(ctx context.Context, vaultBaseURL string, secretName string, secretVersion string) (SecretInfo, error) {
+	defer timeTrack(time.Now(), "getSecretInfo")
+	ref := SecretRef{VaultBaseURL: vaultBaseURL, Name: secretName, Version: secretVersion}
+	var bundle keyvault.SecretBundle
+	err := c.do(ctx, "GetSecret "+secretName, func(ctx context.Context) (err error) {
+		bundle, err = c.secrets.GetSecret(ctx, vaultBaseURL, secretName, secretVersion)
+		return err
+	})
+	if err != nil {
+		c.audit.secretRead(ref, "", false, err)
+		return SecretInfo{}, err
+	}
+	bundle.Value = nil
+
+	info := SecretInfo{Name: secretName, Tags: map[string]string{}}
+	if bundle.ID != nil {
+		info.ID = *bundle.ID
+		info.Version = SecretVersionFromID(*bundle.ID)
+	}
+	if bundle.ContentType != nil {
+		info.ContentType = *bundle.ContentType
+	}
+	for k, v := range bundle.Tags {
+		if v != nil {
+			info.Tags[k] = *v
+		}
+	}
+	if bundle.Attributes != nil {
+		info.Enabled = bundle.Attributes.Enabled != nil && *bundle.Attributes.Enabled
+		info.Created = unixTime(bundle.Attributes.Created)
+		info.Updated = unixTime(bundle.Attributes.Updated)
+		info.NotBefore = unixTime(bundle.Attributes.NotBefore)
+		info.Expires = unixTime(bundle.Attributes.Expires)
+		info.RecoveryLevel = string(bundle.Attributes.RecoveryLevel)
+	}
+	info.Managed = bundle.Managed != nil && *bundle.Managed
+	c.audit.secretRead(ref, info.Version, false, nil)
+	return info, nil
+}
+
+// SecretsError reports the secrets GetSecrets could not fetch, keyed by secret name.
+type SecretsError map[string]error
+
+func (e SecretsError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = fmt.Sprintf("%s: %v", name, e[name])
+	}
+	return fmt.Sprintf("could not get %d secret(s): %s", len(e), strings.Join(messages, "; "))
+}
+
+// SecretError is why one secret of a batch, such as Run's, couldn't be fetched.
+type SecretError struct {
+	// Secret names the secret as its SecretsError does: by SecretRef.String from Run and GetSecretRefs,
+	// by name from GetSecrets.
+	Secret string
+	// Cause classifies Err: "not found", "access denied", "authentication failed", "throttled",
+	// "timed out", "network error", "expired", "not yet active" or, for anything else, "failed".
+	Cause string
+	Err   error
+}
+
+func (e *SecretError) Error() string {
+	return fmt.Sprintf("secret %s: %s: %v", e.Secret, e.Cause, e.Err)
+}
+
+func (e *SecretError) Unwrap() error {
+	return e.Err
+}
+
+// Join makes a single error of e with errors.Join, one SecretError per secret in order of name, so each
+// failure can be reached with errors.As and the sentinels with errors.Is.
+func (e SecretsError) Join() error {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := make([]error, len(names))
+	for i, name := range names {
+		errs[i] = &SecretError{Secret: name, Cause: failureCause(e[name]), Err: e[name]}
+	}
+	return errors.Join(errs...)
+}
+
+// failureCause classifies the error a secret couldn't be fetched with for SecretError.Cause.
+func failureCause(err error) string {
+	var de autorest.DetailedError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrSecretNotFound):
+		return "not found"
+	case errors.Is(err, ErrAccessDenied):
+		return "access denied"
+	case errors.Is(err, ErrAuthentication):
+		return "authentication failed"
+	case errors.Is(err, ErrThrottled):
+		return "throttled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.Is(err, ErrSecretExpired):
+		return "expired"
+	case errors.Is(err, ErrSecretNotYetActive):
+		return "not yet active"
+	case errors.As(err, &de) && errors.As(de.Original, &netErr):
+		return "network error"
+	}
+	return "failed"
+}
+
+// GetSecrets fetches the current version of each named secret concurrently, with at most
+// Config.MaxConcurrency requests in flight. The returned map holds every secret that was fetched;
+// if any failed the error is a SecretsError naming them.
+func (c *Client) GetSecrets(ctx context.Context, vaultBaseURL string, names []string) (map[string]string, error) {
+	secrets, err := c.GetSecretsDetails(ctx, vaultBaseURL, names)
+	values := make(map[string]string, len(secrets))
+	for name, secret := range secrets {
+		values[name] = string(secret.Value)
+	}
+	return values, err
+}
+
+// GetSecretsByPrefix fetches the current version of every enabled secret whose name starts with prefix,
+// such as all the "myapp-" secrets of an application sharing a vault, keyed by name. Key Vault can't
+// filter by name, so the vault is listed and the names matched here; the values are then fetched as by
+// GetSecrets, within Config.MaxConcurrency and Config.RequestsPerSecond.
+func (c *Client) GetSecretsByPrefix(ctx context.Context, vaultBaseURL string, prefix string) (map[string]string, error) {
+	defer timeTrack(time.Now(), "getSecretsByPrefix")
+	ids, err := c.ListSecretsFiltered(ctx, vaultBaseURL, ListOptions{NamePrefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = SecretNameFromID(id)
+	}
+	return c.GetSecrets(ctx, vaultBaseURL, names)
+}
+
+// GetSecretsDetails is GetSecrets, returning each secret's properties along with its value.
+func (c *Client) GetSecretsDetails(ctx context.Context, vaultBaseURL string, names []string) (map[string]Secret, error) {
+	defer timeTrack(time.Now(), "getSecrets")
+	refs := make([]SecretRef, len(names))
+	for i, name := range names {
+		refs[i] = SecretRef{VaultBaseURL: vaultBaseURL, Name: name}
+	}
+
+	fetched, errs := c.getSecretRefs(ctx, refs)
+	secrets := make(map[string]Secret, len(fetched))
+	for ref, secret := range fetched {
+		secrets[ref.Name] = secret
+	}
+	if len(errs) > 0 {
+		failed := SecretsError{}
+		for ref, err := range errs {
+			failed[ref.Name] = err
+		}
+		return secrets, failed
+	}
+	return secrets, nil
+}
+
+// SecretRef identifies a secret in a particular vault. An empty Version means the current version.
+type SecretRef struct {
+	VaultBaseURL string
+	Name         string
+	Version      string
+}
+
+// String returns the secret identifier, e.g. https://myvault.vault.azure.net/secrets/UserName.
+func (r SecretRef) String() string {
+	id := strings.TrimSuffix(r.VaultBaseURL, "/") + "/secrets/" + r.Name
+	if r.Version != "" {
+		id += "/" + r.Version
+	}
+	return id
+}
+
+// GetSecretRefs is GetSecretsDetails for secrets that may live in different vaults and be pinned to
+// particular versions. If any failed the error is a SecretsError keyed by SecretRef.String.
+func (c *Client) GetSecretRefs(ctx context.Context, refs []SecretRef) (map[SecretRef]Secret, error) {
+	defer timeTrack(time.Now(), "getSecretRefs")
+	fetched, errs := c.getSecretRefs(ctx, refs)
+	if len(errs) > 0 {
+		failed := SecretsError{}
+		for ref, err := range errs {
+			failed[ref.String()] = err
+		}
+		return fetched, failed
+	}
+	return fetched, nil
+}
+
+// getSecretRefs fetches refs with at most Config.MaxConcurrency requests in flight, returning the
+// secrets that were fetched and the errors for those that weren't.
+func (c *Client) getSecretRefs(ctx context.Context, refs []SecretRef) (map[SecretRef]Secret, map[SecretRef]error) {
+	type result struct {
+		ref    SecretRef
+		secret Secret
+		err    error
+	}
+
+	work := make(chan SecretRef)
+	results := make(chan result)
+
+	workers := c.maxConcurrency
+	if workers > len(refs) {
+		workers = len(refs)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range work {
+				secret, err := c.GetSecretDetails(ctx, ref.VaultBaseURL, ref.Name, ref.Version)
+				results <- result{ref: ref, secret: secret, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, ref := range refs {
+			work <- ref
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	secrets := make(map[SecretRef]Secret, len(refs))
+	failed := map[SecretRef]error{}
+	for r := range results {
+		if r.err != nil {
+			failed[r.ref] = r.err
+			continue
+		}
+		secrets[r.ref] = r.secret
+	}
+	return secrets, failed
+}
+
+// SetSecret creates a new version of the named secret and returns its version id.
+// The value is never logged.
+func (c *Client) SetSecret(ctx context.Context, vaultBaseURL string, secretName string, value string, opts SecretOptions) (string, error) {
+	defer timeTrack(time.Now(), "setSecret")
+	// Drops anything cached for the secret once the change has been made.
+	defer c.cache.invalidate(vaultBaseURL, secretName)
+	params := keyvault.SecretSetParameters{
+		Value: &value,
+	}
+	if opts.ContentType != "" {
+		params.ContentType = &opts.ContentType
+	}
+	if len(opts.Tags) > 0 {
+		params.Tags = make(map[string]*string, len(opts.Tags))
+		for k, v := range opts.Tags {
+			v := v
+			params.Tags[k] = &v
+		}
+	}
+	if opts.NotBefore != nil || opts.Expires != nil {
+		params.SecretAttributes = &keyvault.SecretAttributes{}
+		if opts.NotBefore != nil {
+			nbf := date.UnixTime(*opts.NotBefore)
+			params.SecretAttributes.NotBefore = &nbf
+		}
+		if opts.Expires != nil {
+			exp := date.UnixTime(*opts.Expires)
+			params.SecretAttributes.Expires = &exp
+		}
+	}
+
+	var secretBundle keyvault.SecretBundle
+	err := c.do(ctx, "SetSecret "+secretName, func(ctx context.Context) (err error) {
+		secretBundle, err = c.kv.SetSecret(ctx, vaultBaseURL, secretName, params)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if secretBundle.ID == nil {
+		return "", fmt.Errorf("Key Vault returned no id for secret %s", secretName)
+	}
+	log.Debugf("Set secret. name=%q", secretName)
+	return SecretVersionFromID(*secretBundle.ID), nil
+}
+
+// SecretUpdate holds the properties UpdateSecretAttributes changes. Nil fields, and a nil Tags map, are
+// left as they are; a non-nil Tags map replaces all the secret's tags.
+type SecretUpdate struct {
+	Enabled     *bool
+	NotBefore   *time.Time
+	Expires     *time.Time
+	ContentType *string
+	Tags        map[string]string
+}
+
+// UpdateSecretAttributes changes the properties of a secret version without touching its value. An empty
+// secretVersion updates the current version. Reading the version first needs the get secret permission
+// in addition to set.
+func (c *Client) UpdateSecretAttributes(ctx context.Context, vaultBaseURL string, secretName string, secretVersion string, attrs SecretUpdate) (keyvault.SecretBundle, error) {
+	defer timeTrack(time.Now(), "updateSecretAttributes")
+	defer c.cache.invalidate(vaultBaseURL, secretName)
+	// The service addresses updates by version, and the SDK sends "tags": null when none are given, so
+	// the version is resolved and its tags carried over.
+	var current keyvault.SecretBundle
+	err := c.do(ctx, "GetSecret "+secretName, func(ctx context.Context) (err error) {
+		current, err = c.kv.GetSecret(ctx, vaultBaseURL, secretName, secretVersion)
+		return err
+	})
+	if err != nil {
+		return keyvault.SecretBundle{}, err
+	}
+	if secretVersion == "" {
+		if current.ID == nil {
+			return keyvault.SecretBundle{}, fmt.Errorf("Key Vault returned no id for secret %s", secretName)
+		}
+		secretVersion = SecretVersionFromID(*current.ID)
+	}
+
+	params := keyvault.SecretUpdateParameters{
+		ContentType: attrs.ContentType,
+		Tags:        current.Tags,
+	}
+	if attrs.Tags != nil {
+		params.Tags = make(map[string]*string, len(attrs.Tags))
+		for k, v := range attrs.Tags {
+			v := v
+			params.Tags[k] = &v
+		}
+	}
+	if attrs.Enabled != nil || attrs.NotBefore != nil || attrs.Expires != nil {
+		params.SecretAttributes = &keyvault.SecretAttributes{Enabled: attrs.Enabled}
+		if attrs.NotBefore != nil {
+			nbf := date.UnixTime(*attrs.NotBefore)
+			params.SecretAttributes.NotBefore = &nbf
+		}
+		if attrs.Expires != nil {
+			exp := date.UnixTime(*attrs.Expires)
+			params.SecretAttributes.Expires = &exp
+		}
+	}
+
+	var secretBundle keyvault.SecretBundle
+	err = c.do(ctx, "UpdateSecret "+secretName, func(ctx context.Context) (err error) {
+		secretBundle, err = c.kv.UpdateSecret(ctx, vaultBaseURL, secretName, secretVersion, params)
+		return err
+	})
+	if err != nil {
+		return keyvault.SecretBundle{}, err
+	}
+	log.Debugf("Updated secret. name=%q version=%q", secretName, secretVersion)
+	return secretBundle, nil
+}
+
+// Ping checks that the vault can be reached and the client is authorized to list its secrets, reading
+// at most one secret identifier. It is meant for health checks.
+func (c *Client) Ping(ctx context.Context, vaultBaseURL string) error {
+	defer timeTrack(time.Now(), "ping")
+	one := int32(1)
+	return c.do(ctx, "Ping", func(ctx context.Context) error {
+		_, err := c.kv.GetSecrets(ctx, vaultBaseURL, &one)
+		return err
+	})
+}
+
+// ListOptions selects the secrets ListSecretsFiltered returns.
+type ListOptions struct {
+	// IncludeDisabled includes secrets whose Enabled attribute is false.
+	IncludeDisabled bool
+	// Tags, when set, limits the result to secrets carrying every one of these tags with these values.
+	Tags map[string]string
+	// NamePrefix, when set, limits the result to secrets whose name starts with it. Secret names are
+	// case-insensitive in Key Vault, and so is the match.
+	NamePrefix string
+	// ChangedSince, when set, limits the result to secrets created or updated after it, as for an
+	// incremental sync. A secret whose attributes the listing leaves out is kept, as it may have changed.
+	ChangedSince time.Time
+	// MaxResults, when positive, stops listing once this many secrets have been returned, without
+	// requesting the remaining pages.
+	MaxResults int
+}
+
+// ListSecrets returns the identifiers of all secrets in the vault, following nextLink until every page has been read.
+// Disabled secrets are skipped unless includeDisabled is set.
+func (c *Client) ListSecrets(ctx context.Context, vaultBaseURL string, includeDisabled bool) ([]string, error) {
+	return c.ListSecretsFiltered(ctx, vaultBaseURL, ListOptions{IncludeDisabled: includeDisabled})
+}
+
+// ListSecretsFiltered is ListSecrets, returning only the secrets opts selects. Tags are filtered on the
+// list results, so no extra request is made per secret.
+func (c *Client) ListSecretsFiltered(ctx context.Context, vaultBaseURL string, opts ListOptions) ([]string, error) {
+	var ids []string
+	err := c.ListSecretsIter(ctx, vaultBaseURL, opts, func(id string) bool {
+		ids = append(ids, id)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListSecretsIter is ListSecretsFiltered, calling fn with each identifier as its page arrives instead of
+// collecting them, so listing a large vault needs memory for only one page. Returning false from fn stops
+// the listing; no further pages are requested. A page that fails is retried on its own, so fn never sees
+// an identifier twice.
+func (c *Client) ListSecretsIter(ctx context.Context, vaultBaseURL string, opts ListOptions, fn func(id string) bool) error {
+	defer timeTrack(time.Now(), "listSecrets")
+	var page keyvault.SecretListResultPage
+	err := c.do(ctx, "ListSecrets", func(ctx context.Context) (err error) {
+		page, err = c.kv.GetSecrets(ctx, vaultBaseURL, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	listed := 0
+	for page.NotDone() {
+		for _, item := range page.Values() {
+			if item.ID == nil {
+				continue
+			}
+			if !opts.IncludeDisabled && item.Attributes != nil && item.Attributes.Enabled != nil && !*item.Attributes.Enabled {
+				continue
+			}
+			if !hasTags(item.Tags, opts.Tags) || !hasNamePrefix(SecretNameFromID(*item.ID), opts.NamePrefix) {
+				continue
+			}
+			if !opts.ChangedSince.IsZero() && !changedSince(item.Attributes, opts.ChangedSince) {
+				continue
+			}
+			if !fn(*item.ID) {
+				return nil
+			}
+			listed++
+			if opts.MaxResults > 0 && listed >= opts.MaxResults {
+				return nil
+			}
+		}
+		err = c.do(ctx, "ListSecrets next page", func(ctx context.Context) error {
+			return nextPage(ctx, page.Next)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasNamePrefix reports whether name starts with prefix, ignoring case.
+func hasNamePrefix(name string, prefix string) bool {
+	return len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+}
+
+// changedSince reports whether a secret with attrs was created or updated after t, or may have been
+// because attrs doesn't say.
+func changedSince(attrs *keyvault.SecretAttributes, t time.Time) bool {
+	if attrs == nil || (attrs.Created == nil && attrs.Updated == nil) {
+		return true
+	}
+	return unixTime(attrs.Created).After(t) || unixTime(attrs.Updated).After(t)
+}
+
+// hasTags reports whether tags holds every key in want with the same value.
+func hasTags(tags map[string]*string, want map[string]string) bool {
+	for k, v := range want {
+		if tag, ok := tags[k]; !ok || tag == nil || *tag != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SecretVersionInfo describes one version of a secret, without its value.
+type SecretVersionInfo struct {
+	Version string
+	Created time.Time
+	Updated time.Time
+	Enabled bool
+}
+
+// ListSecretVersions returns every version of the named secret, newest first.
+func (c *Client) ListSecretVersions(ctx context.Context, vaultBaseURL string, secretName string) ([]SecretVersionInfo, error) {
+	defer timeTrack(time.Now(), "listSecretVersions")
+	var versions []SecretVersionInfo
+	err := c.do(ctx, "ListSecretVersions "+secretName, func(ctx context.Context) error {
+		versions = nil
+		page, err := c.kv.GetSecretVersions(ctx, vaultBaseURL, secretName, nil)
+		if err != nil {
+			return err
+		}
+		for page.NotDone() {
+			for _, item := range page.Values() {
+				if item.ID == nil {
+					continue
+				}
+				info := SecretVersionInfo{Version: SecretVersionFromID(*item.ID)}
+				if item.Attributes != nil {
+					info.Created = unixTime(item.Attributes.Created)
+					info.Updated = unixTime(item.Attributes.Updated)
+					info.Enabled = item.Attributes.Enabled != nil && *item.Attributes.Enabled
+				}
+				versions = append(versions, info)
+			}
+			err = nextPage(ctx, page.Next)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Created.After(versions[j].Created)
+	})
+	return versions, nil
+}
+
+// DeleteSecret deletes all versions of a secret. On a soft-delete enabled vault the returned bundle
+// carries the ScheduledPurgeDate after which the secret can no longer be recovered.
+func (c *Client) DeleteSecret(ctx context.Context, vaultBaseURL string, secretName string) (keyvault.DeletedSecretBundle, error) {
+	defer timeTrack(time.Now(), "deleteSecret")
+	defer c.cache.invalidate(vaultBaseURL, secretName)
+	var deleted keyvault.DeletedSecretBundle
+	err := c.do(ctx, "DeleteSecret "+secretName, func(ctx context.Context) (err error) {
+		deleted, err = c.kv.DeleteSecret(ctx, vaultBaseURL, secretName)
+		return err
+	})
+	if err != nil {
+		return deleted, classifySoftDeleteError(secretName, err)
+	}
+	return deleted, nil
+}
+
+// RecoverDeletedSecret restores a soft-deleted secret to its latest version.
+func (c *Client) RecoverDeletedSecret(ctx context.Context, vaultBaseURL string, secretName string) error {
+	defer timeTrack(time.Now(), "recoverDeletedSecret")
+	defer c.cache.invalidate(vaultBaseURL, secretName)
+	err := c.do(ctx, "RecoverDeletedSecret "+secretName, func(ctx context.Context) error {
+		_, err := c.kv.RecoverDeletedSecret(ctx, vaultBaseURL, secretName)
+		return err
+	})
+	if err != nil {
+		return classifySoftDeleteError(secretName, err)
+	}
+	return nil
+}
+
+// DeletedSecretInfo describes a soft-deleted secret, which RecoverDeletedSecret can restore until its
+// ScheduledPurgeDate.
+type DeletedSecretInfo struct {
+	Name               string
+	DeletedDate        time.Time
+	ScheduledPurgeDate time.Time
+}
+
+// ListDeletedSecrets returns the soft-deleted secrets in the vault, following nextLink until every page
+// has been read, ordered by name.
+func (c *Client) ListDeletedSecrets(ctx context.Context, vaultBaseURL string) ([]DeletedSecretInfo, error) {
+	defer timeTrack(time.Now(), "listDeletedSecrets")
+	var deleted []DeletedSecretInfo
+	err := c.do(ctx, "ListDeletedSecrets", func(ctx context.Context) error {
+		deleted = nil
+		page, err := c.kv.GetDeletedSecrets(ctx, vaultBaseURL, nil)
+		if err != nil {
+			return err
+		}
+		for page.NotDone() {
+			for _, item := range page.Values() {
+				var name string
+				switch {
+				case item.RecoveryID != nil:
+					name = objectNameFromID(*item.RecoveryID, "deletedsecrets")
+				case item.ID != nil:
+					name = SecretNameFromID(*item.ID)
+				default:
+					continue
+				}
+				deleted = append(deleted, DeletedSecretInfo{
+					Name:               name,
+					DeletedDate:        unixTime(item.DeletedDate),
+					ScheduledPurgeDate: unixTime(item.ScheduledPurgeDate),
+				})
+			}
+			err = nextPage(ctx, page.Next)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if se := serviceError(err); se != nil && strings.Contains(se.Message, "not enabled for this vault") {
+		return nil, fmt.Errorf("%w: %s", ErrSoftDeleteNotEnabled, se.Message)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Name < deleted[j].Name
+	})
+	return deleted, nil
+}
+
+// PurgeDeletedSecret permanently removes a soft-deleted secret.
+func (c *Client) PurgeDeletedSecret(ctx context.Context, vaultBaseURL string, secretName string) error {
+	defer timeTrack(time.Now(), "purgeDeletedSecret")
+	defer c.cache.invalidate(vaultBaseURL, secretName)
+	err := c.do(ctx, "PurgeDeletedSecret "+secretName, func(ctx context.Context) error {
+		_, err := c.kv.PurgeDeletedSecret(ctx, vaultBaseURL, secretName)
+		return err
+	})
+	if err != nil {
+		return classifySoftDeleteError(secretName, err)
+	}
+	return nil
+}
+
+// BackupSecret returns a protected blob holding every version of a secret. It can only be restored into
+// a vault in the same Azure geography and subscription.
+func (c *Client) BackupSecret(ctx context.Context, vaultBaseURL string, secretName string) ([]byte, error) {
+	defer timeTrack(time.Now(), "backupSecret")
+	var result keyvault.BackupSecretResult
+	err := c.do(ctx, "BackupSecret "+secretName, func(ctx context.Context) (err error) {
+		result, err = c.kv.BackupSecret(ctx, vaultBaseURL, secretName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBase64URL(result.Value)
+}
+
+// RestoreSecret recreates a secret, with all its versions, from a blob returned by BackupSecret and
+// returns its name. The secret must not already exist in the vault.
+func (c *Client) RestoreSecret(ctx context.Context, vaultBaseURL string, backup []byte) (string, error) {
+	defer timeTrack(time.Now(), "restoreSecret")
+	if !bytes.HasPrefix(backup, secretBackupHeader) {
+		return "", ErrInvalidBackup
+	}
+	params := keyvault.SecretRestoreParameters{
+		SecretBundleBackup: encodeBase64URL(backup),
+	}
+
+	var secretBundle keyvault.SecretBundle
+	err := c.do(ctx, "RestoreSecret", func(ctx context.Context) (err error) {
+		secretBundle, err = c.kv.RestoreSecret(ctx, vaultBaseURL, params)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if secretBundle.ID == nil {
+		return "", nil
+	}
+	return SecretNameFromID(*secretBundle.ID), nil
+}
+
+// SecretNameFromID extracts the secret name from an identifier such as
+// https://myvault.vault.azure.net/secrets/UserName or .../secrets/UserName/<version>.
+func SecretNameFromID(id string) string {
+	return objectNameFromID(id, "secrets")
+}
+
+// objectNameFromID extracts the name from a Key Vault identifier of the form .../<collection>/<name>,
+// optionally followed by a version, returning id itself when it isn't one.
+func objectNameFromID(id string, collection string) string {
+	u, err := url.Parse(id)
+	if err != nil {
+		return id
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != collection {
+		return id
+	}
+	return parts[1]
+}
+
+// SecretVersionFromID extracts the version from an identifier of the form .../secrets/<name>/<version>.
+func SecretVersionFromID(id string) string {
+	return objectVersionFromID(id, "secrets")
+}
+
+// objectVersionFromID extracts the version from a Key Vault identifier of the form .../<collection>/<name>/<version>.
+func objectVersionFromID(id string, collection string) string {
+	u, err := url.Parse(id)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != collection {
+		return ""
+	}
+	return parts[2]
+}
+
+// nextPage advances a list result, checking ctx first because the SDK does not pass the
+// caller's context on to requests for subsequent pages.
+func nextPage(ctx context.Context, next func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return next()
+}
+
+// unixTime converts an optional SDK timestamp, returning the zero time for nil.
+func unixTime(t *date.UnixTime) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Time(*t)
+}
+
+// classifySoftDeleteError maps SDK errors from the delete, recover and purge operations onto
+// ErrSecretNotFound and ErrSoftDeleteNotEnabled so callers can tell the two apart.
+func classifySoftDeleteError(secretName string, err error) error {
+	if se := serviceError(err); se != nil && strings.Contains(se.Message, "not enabled for this vault") {
+		return fmt.Errorf("%w: %s", ErrSoftDeleteNotEnabled, se.Message)
+	}
+	if statusCode(err) == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, secretName)
+	}
+	return err
+}
+
+// statusErrors are the sentinel errors matched by SDK errors with these status codes.
+var statusErrors = map[int]error{
+	http.StatusUnauthorized:    ErrAuthentication,
+	http.StatusNotFound:        ErrSecretNotFound,
+	http.StatusForbidden:       ErrAccessDenied,
+	http.StatusTooManyRequests: ErrThrottled,
+}
+
+// classifyError makes an SDK error match the sentinel error for its status code with errors.Is,
+// leaving its message as it was.
+func classifyError(err error) error {
+	var de autorest.DetailedError
+	if errors.As(err, &de) && (errors.Is(de.Original, ErrManagedHSMUnsupported) || errors.Is(de.Original, ErrCircuitOpen)) {
+		// Refused before it was sent; the SDK's wrapping would only bury the reason.
+		return de.Original
+	}
+	if errors.As(err, &de) && de.PackageType == "azure.BearerAuthorizer" {
+		// The token refresh failed before the request was sent; its status code is Azure AD's.
+		return statusError{sentinel: ErrAuthentication, err: err}
+	}
+	if sentinel, ok := statusErrors[statusCode(err)]; ok {
+		return statusError{sentinel: sentinel, err: err}
+	}
+	return err
+}
+
+// statusError is an SDK error classified by classifyError.
+type statusError struct {
+	sentinel error
+	err      error
+}
+
+func (e statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e statusError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e statusError) Unwrap() error {
+	return e.err
+}
+
+// statusCode returns the HTTP status code carried by an SDK error, or 0 if there is none.
+func statusCode(err error) int {
+	var de autorest.DetailedError
+	if errors.As(err, &de) {
+		if code, ok := de.StatusCode.(int); ok {
+			return code
+		}
+	}
+	return 0
+}
+
+// serviceError returns the error payload Key Vault sent back, if the SDK error carries one.
+func serviceError(err error) *azure.ServiceError {
+	var de autorest.DetailedError
+	if !errors.As(err, &de) {
+		return nil
+	}
+	if re, ok := de.Original.(*azure.RequestError); ok {
+		return re.ServiceError
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package keyvaultclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/date"
+)
+
+// nullTokenProvider authorizes nothing, for clients talking to a stub server.
+type nullTokenProvider struct{}
+
+func (nullTokenProvider) Authorizer() (autorest.Authorizer, error) {
+	return autorest.NullAuthorizer{}, nil
+}
+
+// newStubClient returns a Client that sends its requests to server without authorizing them.
+func newStubClient(t *testing.T, server *httptest.Server, cfg Config) *Client {
+	t.Helper()
+	cfg.HTTPClient = server.Client()
+	client, err := NewWithTokenProvider(nullTokenProvider{}, cfg)
+	if err != nil {
+		t.Fatalf("NewWithTokenProvider: %v", err)
+	}
+	return client
+}
+
+// stubVault is a Key Vault that keeps secrets in memory, serving set and get requests for them.
+type stubVault struct {
+	mu       sync.Mutex
+	versions map[string][]map[string]interface{} // each secret's versions, oldest first
+}
+
+func newStubVault() *stubVault {
+	return &stubVault{versions: map[string][]map[string]interface{}{}}
+}
+
+func (v *stubVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "secrets" {
+		http.NotFound(w, r)
+		return
+	}
+	name := parts[1]
+	id := "https://" + r.Host + "/secrets/" + name + "/"
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		var bundle map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bundle["id"] = id + fmt.Sprintf("%032x", len(v.versions[name])+1)
+		v.versions[name] = append(v.versions[name], bundle)
+		writeJSON(w, http.StatusOK, bundle)
+	case http.MethodGet:
+		versions := v.versions[name]
+		for i := len(versions) - 1; i >= 0; i-- {
+			if len(parts) < 3 || parts[2] == "" || versions[i]["id"] == id+parts[2] {
+				writeJSON(w, http.StatusOK, versions[i])
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"error": map[string]string{"code": "SecretNotFound", "message": "Secret not found: " + name},
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestSetSecretThenGetSecret(t *testing.T) {
+	server := httptest.NewTLSServer(newStubVault())
+	defer server.Close()
+	client := newStubClient(t, server, Config{})
+	ctx := context.Background()
+
+	first, err := client.SetSecret(ctx, server.URL, "Password", "hunter2", SecretOptions{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatalf("SetSecret: %v", err)
+	}
+	second, err := client.SetSecret(ctx, server.URL, "Password", "correct horse", SecretOptions{})
+	if err != nil {
+		t.Fatalf("SetSecret: %v", err)
+	}
+	if first == "" || first == second {
+		t.Fatalf("SetSecret returned versions %q and %q, want two distinct ones", first, second)
+	}
+
+	for _, tt := range []struct {
+		version     string
+		want        string
+		wantVersion string
+	}{
+		{"", "correct horse", second},
+		{first, "hunter2", first},
+		{second, "correct horse", second},
+	} {
+		secret, err := client.GetSecretDetails(ctx, server.URL, "Password", tt.version)
+		if err != nil {
+			t.Errorf("GetSecretDetails(%q): %v", tt.version, err)
+			continue
+		}
+		if string(secret.Value) != tt.want || secret.Version != tt.wantVersion {
+			t.Errorf("GetSecretDetails(%q) = %q version %q, want %q version %q", tt.version, string(secret.Value), secret.Version, tt.want, tt.wantVersion)
+		}
+	}
+
+	secret, err := client.GetSecretDetails(ctx, server.URL, "Password", first)
+	if err != nil {
+		t.Fatalf("GetSecretDetails: %v", err)
+	}
+	if secret.ContentType != "text/plain" {
+		t.Errorf("ContentType = %q, want %q", secret.ContentType, "text/plain")
+	}
+}
+
+func TestSetSecretWithoutIDInResponse(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"value": "hunter2"})
+	}))
+	defer server.Close()
+	client := newStubClient(t, server, Config{})
+
+	if version, err := client.SetSecret(context.Background(), server.URL, "Password", "hunter2", SecretOptions{}); err == nil {
+		t.Errorf("SetSecret = %q, nil; want an error for the missing id", version)
+	}
+}
+
+func TestUpdateSecretAttributesWithoutIDInResponse(t *testing.T) {
+	var updates int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			atomic.AddInt32(&updates, 1)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"value": "hunter2"})
+	}))
+	defer server.Close()
+	client := newStubClient(t, server, Config{})
+
+	enabled := false
+	if _, err := client.UpdateSecretAttributes(context.Background(), server.URL, "Password", "", SecretUpdate{Enabled: &enabled}); err == nil {
+		t.Error("UpdateSecretAttributes succeeded, want an error for the missing id")
+	}
+	if n := atomic.LoadInt32(&updates); n != 0 {
+		t.Errorf("%d update(s) were sent without a resolved version, want none", n)
+	}
+}
+
+// fakeSecretGetter answers each GetSecret call with the next of its responses, repeating the last.
+type fakeSecretGetter struct {
+	responses []fakeResponse
+	calls     int
+}
+
+type fakeResponse struct {
+	bundle keyvault.SecretBundle
+	err    error
+}
+
+func (g *fakeSecretGetter) GetSecret(ctx context.Context, vaultBaseURL string, secretName string, secretVersion string) (keyvault.SecretBundle, error) {
+	r := g.responses[len(g.responses)-1]
+	if g.calls < len(g.responses) {
+		r = g.responses[g.calls]
+	}
+	g.calls++
+	return r.bundle, r.err
+}
+
+// statusResponse is the error the SDK returns for a response with status code, and no Retry-After wait.
+func statusResponse(code int) fakeResponse {
+	resp := &http.Response{StatusCode: code, Header: http.Header{"Retry-After": {"0"}}}
+	return fakeResponse{err: autorest.DetailedError{StatusCode: code, Response: resp, Message: http.StatusText(code)}}
+}
+
+func secretBundle(value string, attrs *keyvault.SecretAttributes) fakeResponse {
+	id := "https://myvault.vault.azure.net/secrets/Password/0123456789abcdef0123456789abcdef"
+	return fakeResponse{bundle: keyvault.SecretBundle{Value: &value, ID: &id, Attributes: attrs}}
+}
+
+func TestGetSecret(t *testing.T) {
+	expired := date.UnixTime(time.Now().Add(-time.Hour))
+	for _, tt := range []struct {
+		name      string
+		cfg       Config
+		responses []fakeResponse
+		want      string
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "found",
+			responses: []fakeResponse{secretBundle("hunter2", nil)},
+			want:      "hunter2",
+			wantCalls: 1,
+		},
+		{
+			name:      "not found",
+			responses: []fakeResponse{statusResponse(http.StatusNotFound)},
+			wantErr:   ErrSecretNotFound,
+			wantCalls: 1,
+		},
+		{
+			name:      "throttled then OK",
+			responses: []fakeResponse{statusResponse(http.StatusTooManyRequests), statusResponse(http.StatusTooManyRequests), secretBundle("hunter2", nil)},
+			want:      "hunter2",
+			wantCalls: 3,
+		},
+		{
+			name:      "throttled past MaxRetries",
+			cfg:       Config{MaxRetries: 2},
+			responses: []fakeResponse{statusResponse(http.StatusTooManyRequests)},
+			wantErr:   ErrThrottled,
+			wantCalls: 3,
+		},
+		{
+			name:      "expired",
+			responses: []fakeResponse{secretBundle("hunter2", &keyvault.SecretAttributes{Expires: &expired})},
+			want:      "hunter2",
+			wantCalls: 1,
+		},
+		{
+			name:      "expired under StrictExpiry",
+			cfg:       Config{StrictExpiry: true},
+			responses: []fakeResponse{secretBundle("hunter2", &keyvault.SecretAttributes{Expires: &expired})},
+			wantErr:   ErrSecretExpired,
+			wantCalls: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			getter := &fakeSecretGetter{responses: tt.responses}
+			client := NewWithSecretGetter(getter, tt.cfg)
+
+			got, err := client.GetSecret(context.Background(), "https://myvault.vault.azure.net", "Password", "")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("GetSecret error = %v, want one matching %v", err, tt.wantErr)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("GetSecret = %q, %v; want %q, nil", got, err, tt.want)
+			}
+			if getter.calls != tt.wantCalls {
+				t.Errorf("GetSecret called the getter %d time(s), want %d", getter.calls, tt.wantCalls)
+			}
+		})
+	}
+}
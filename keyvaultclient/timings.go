@@ -0,0 +1,117 @@
+package keyvaultclient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// timings holds every duration timeTrack measured, by operation, and the token cache lookups, once
+// EnableTimings has been called.
+var timings = struct {
+	sync.Mutex
+	enabled    bool
+	m          map[string][]time.Duration
+	tokenCache TokenCacheLookups
+}{m: map[string][]time.Duration{}}
+
+// EnableTimings starts recording how long each operation takes, for TimingSummary. Every duration is
+// kept, so it suits runs that end, such as a bulk fetch, rather than long-running ones.
+func EnableTimings() {
+	timings.Lock()
+	defer timings.Unlock()
+	timings.enabled = true
+}
+
+func timingsEnabled() bool {
+	timings.Lock()
+	defer timings.Unlock()
+	return timings.enabled
+}
+
+// OperationTiming summarizes the recorded durations of one operation, such as getSecret.
+type OperationTiming struct {
+	Operation string
+	Count     int
+	Min       time.Duration
+	Max       time.Duration
+	P50       time.Duration
+	P95       time.Duration
+}
+
+// TimingSummary returns the durations recorded since EnableTimings, by operation name.
+func TimingSummary() []OperationTiming {
+	timings.Lock()
+	defer timings.Unlock()
+	summary := make([]OperationTiming, 0, len(timings.m))
+	for operation, durations := range timings.m {
+		sorted := append([]time.Duration(nil), durations...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		summary = append(summary, OperationTiming{
+			Operation: operation,
+			Count:     len(sorted),
+			Min:       sorted[0],
+			Max:       sorted[len(sorted)-1],
+			P50:       percentile(sorted, 50),
+			P95:       percentile(sorted, 95),
+		})
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Operation < summary[j].Operation })
+	return summary
+}
+
+// TokenCacheLookups counts reads of the token cache by result, as the token_cache_lookups_total metric
+// does: Hit when the cached token was used, Missing when there was none and Expired when it was within
+// the refresh skew of expiry.
+type TokenCacheLookups struct {
+	Hit     int
+	Missing int
+	Expired int
+}
+
+// Total is the number of lookups.
+func (l TokenCacheLookups) Total() int {
+	return l.Hit + l.Missing + l.Expired
+}
+
+// TokenCacheSummary returns the token cache lookups recorded since EnableTimings.
+func TokenCacheSummary() TokenCacheLookups {
+	timings.Lock()
+	defer timings.Unlock()
+	return timings.tokenCache
+}
+
+// percentile returns the nearest-rank pth percentile of sorted, which must not be empty.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// recordTiming keeps elapsed for operation, if timings are enabled.
+func recordTiming(operation string, elapsed time.Duration) {
+	timings.Lock()
+	defer timings.Unlock()
+	if timings.enabled {
+		timings.m[operation] = append(timings.m[operation], elapsed)
+	}
+}
+
+// recordTokenCacheLookup counts a lookup with result hit, missing or expired, if timings are enabled.
+func recordTokenCacheLookup(result string) {
+	timings.Lock()
+	defer timings.Unlock()
+	if !timings.enabled {
+		return
+	}
+	switch result {
+	case "hit":
+		timings.tokenCache.Hit++
+	case "missing":
+		timings.tokenCache.Missing++
+	case "expired":
+		timings.tokenCache.Expired++
+	}
+}
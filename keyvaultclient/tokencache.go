@@ -0,0 +1,328 @@
+package keyvaultclient
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// An encrypted cache file holds a random scrypt salt, the AES-GCM nonce and then the sealed token JSON.
+const tokenCacheSaltSize = 16
+
+// tokenCacheDir returns Config.TokenCacheDir, defaulting to goAzureKeyVault under the user's cache
+// directory (e.g. ~/.cache on Linux), or ./cache if the OS doesn't define one.
+func tokenCacheDir(cfg Config) string {
+	if cfg.TokenCacheDir != "" {
+		return cfg.TokenCacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		log.Debugf("No user cache directory, using ./cache: %v", err)
+		return "cache"
+	}
+	return filepath.Join(dir, "goAzureKeyVault")
+}
+
+func tryLoadCachedToken(cachePath string, key string) (*adal.Token, error) {
+
+	// Check for file not found so we can suppress the file not found error
+	// LoadToken doesn't discern and returns error either way
+	defer timeTrack(time.Now(), "tryLoadCachedToken")
+	if _, err := os.Stat(cachePath); err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("Cache path does not exist. Path=%q", cachePath)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if key == "" {
+		token, err := adal.LoadToken(cachePath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load token from file: %v", err)
+		}
+		return token, nil
+	}
+
+	data, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load token from file: %v", err)
+	}
+	token, err := decryptToken(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt token from file: %v", err)
+	}
+	return token, nil
+}
+
+// saveCachedToken writes the token to cachePath with 0600 permissions, encrypted when key is set.
+// The cache directory is created with 0700 permissions if it doesn't exist.
+func saveCachedToken(cachePath string, key string, token adal.Token) error {
+	dir := filepath.Dir(cachePath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory (%s) to store token in: %v", dir, err)
+	}
+
+	if key == "" {
+		return adal.SaveToken(cachePath, 0600, token)
+	}
+
+	data, err := encryptToken(token, key)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "token")
+	if err != nil {
+		return fmt.Errorf("failed to create the temp file to write the token: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token to temp file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("failed to chmod the token file %s: %v", tmp.Name(), err)
+	}
+	return os.Rename(tmp.Name(), cachePath)
+}
+
+func encryptToken(token adal.Token, passphrase string) ([]byte, error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, tokenCacheSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := tokenCacheCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := append(salt, nonce...)
+	return gcm.Seal(data, nonce, plaintext, nil), nil
+}
+
+func decryptToken(data []byte, passphrase string) (*adal.Token, error) {
+	if len(data) < tokenCacheSaltSize {
+		return nil, errors.New("cache file is too short")
+	}
+	salt, data := data[:tokenCacheSaltSize], data[tokenCacheSaltSize:]
+	gcm, err := tokenCacheCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("cache file is too short")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, err
+	}
+	var token adal.Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// tokenCacheCipher derives an AES-256-GCM cipher from the passphrase.
+func tokenCacheCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// tokenRefreshSkew returns Config.TokenRefreshSkew, defaulting to DefaultTokenRefreshSkew.
+func tokenRefreshSkew(cfg Config) time.Duration {
+	if cfg.TokenRefreshSkew <= 0 {
+		return DefaultTokenRefreshSkew
+	}
+	return cfg.TokenRefreshSkew
+}
+
+// syncRefresher makes a ServicePrincipalToken safe to share between goroutines, as every Client created
+// with the same Config shares one. adal's EnsureFresh reads the token before taking its lock, which races
+// with a refresh in progress on another goroutine. Here the token is read through its lock, and a refresh
+// is only started holding mu, so concurrent requests near expiry wait for a single refresh rather than
+// racing it. Token refresh callbacks, and so token cache writes, happen one at a time as a result.
+type syncRefresher struct {
+	mu            sync.Mutex
+	spt           *adal.ServicePrincipalToken
+	refreshWithin time.Duration
+}
+
+func newSyncRefresher(spt *adal.ServicePrincipalToken, refreshWithin time.Duration) *syncRefresher {
+	return &syncRefresher{spt: spt, refreshWithin: refreshWithin}
+}
+
+// OAuthToken implements adal.OAuthTokenProvider.
+func (r *syncRefresher) OAuthToken() string {
+	return r.spt.OAuthToken()
+}
+
+func (r *syncRefresher) expiry() time.Time {
+	return r.spt.Token().Expires()
+}
+
+// EnsureFresh implements adal.Refresher.
+func (r *syncRefresher) EnsureFresh() error {
+	if !r.spt.Token().WillExpireIn(r.refreshWithin) {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// adal checks the expiry again, so a request that waited for mu doesn't refresh a second time.
+	return r.spt.EnsureFresh()
+}
+
+// Refresh implements adal.Refresher.
+func (r *syncRefresher) Refresh() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spt.Refresh()
+}
+
+// RefreshExchange implements adal.Refresher.
+func (r *syncRefresher) RefreshExchange(resource string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spt.RefreshExchange(resource)
+}
+
+// cachedTokenRefresher serves a token loaded from the cache. adal can't refresh a manually created
+// token, as it holds no client secret, so once the cached token is within refreshWithin of expiry it is
+// replaced by one requested with the Service Principal's credentials, which refreshes itself from then on.
+// It is safe for concurrent use: the swap happens once, under mu.
+type cachedTokenRefresher struct {
+	mu            sync.RWMutex
+	token         *adal.ServicePrincipalToken
+	renewed       *syncRefresher
+	refreshWithin time.Duration
+	maxRetries    int
+	newToken      func() (*adal.ServicePrincipalToken, error)
+}
+
+// OAuthToken implements adal.OAuthTokenProvider.
+func (r *cachedTokenRefresher) OAuthToken() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.renewed != nil {
+		return r.renewed.OAuthToken()
+	}
+	return r.token.OAuthToken()
+}
+
+func (r *cachedTokenRefresher) expiry() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.renewed != nil {
+		return r.renewed.expiry()
+	}
+	return r.token.Token().Expires()
+}
+
+// EnsureFresh implements adal.Refresher.
+func (r *cachedTokenRefresher) EnsureFresh() error {
+	r.mu.RLock()
+	token, renewed := r.token, r.renewed
+	r.mu.RUnlock()
+	if renewed != nil {
+		return renewed.EnsureFresh()
+	}
+	if !token.Token().WillExpireIn(r.refreshWithin) {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.renewed != nil {
+		// Another request renewed it while we waited for the lock.
+		return nil
+	}
+	return r.renewLocked()
+}
+
+// Refresh implements adal.Refresher.
+func (r *cachedTokenRefresher) Refresh() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.renewed != nil {
+		return r.renewed.Refresh()
+	}
+	return r.renewLocked()
+}
+
+// RefreshExchange implements adal.Refresher.
+func (r *cachedTokenRefresher) RefreshExchange(resource string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.renewed == nil {
+		if err := r.renewLocked(); err != nil {
+			return err
+		}
+	}
+	return r.renewed.RefreshExchange(resource)
+}
+
+// renewLocked swaps the cached token for a freshly requested one. r.mu must be held.
+func (r *cachedTokenRefresher) renewLocked() error {
+	spt, err := r.newToken()
+	if err != nil {
+		return err
+	}
+	if err := refreshWithRetry(spt.Refresh, r.maxRetries); err != nil {
+		return refreshError(err)
+	}
+	r.renewed = newSyncRefresher(spt, r.refreshWithin)
+	return nil
+}
+
+// tokenProvider is a token provider that can tell when its current token expires.
+type tokenProvider interface {
+	adal.OAuthTokenProvider
+	expiry() time.Time
+}
+
+// tokenAuthorizer is autorest's bearer authorizer, which keeps its token provider to itself, along with
+// the provider, so Client.TokenExpiresOn can read the token's expiry.
+type tokenAuthorizer struct {
+	*autorest.BearerAuthorizer
+	provider tokenProvider
+}
+
+func newTokenAuthorizer(tp tokenProvider) *tokenAuthorizer {
+	return &tokenAuthorizer{BearerAuthorizer: autorest.NewBearerAuthorizer(tp), provider: tp}
+}
@@ -0,0 +1,195 @@
+package keyvaultclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// tokenStubLifetime is how long the stub's tokens last. With a refresh skew of one second less, each is
+// refreshed about a second after it was issued.
+const tokenStubLifetime = 2 * time.Second
+
+// tokenStub is Azure AD's token endpoint and a vault in one server. It issues short-lived tokens and only
+// serves secrets to requests bearing one that hasn't expired.
+type tokenStub struct {
+	t testing.TB
+
+	issued      int32 // tokens issued
+	inFlight    int32 // token requests being handled
+	maxInFlight int32 // the most token requests handled at once
+	reads       int32 // secrets served
+
+	mu      sync.Mutex
+	expires map[string]time.Time // when each issued access token expires
+}
+
+func newTokenStub(t testing.TB) *tokenStub {
+	return &tokenStub{t: t, expires: map[string]time.Time{}}
+}
+
+func (s *tokenStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/oauth2/token") {
+		s.serveToken(w, r)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	expires, ok := s.expires[token]
+	s.mu.Unlock()
+	if !ok || time.Now().After(expires) {
+		// Reported rather than just answered with 401, which a test would only see as a failed read.
+		s.t.Errorf("vault request with an unknown or expired token %q", token)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	atomic.AddInt32(&s.reads, 1)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"id":    "https://" + r.Host + "/secrets/Password/0123456789abcdef0123456789abcdef",
+		"value": "hunter2",
+	})
+}
+
+func (s *tokenStub) serveToken(w http.ResponseWriter, r *http.Request) {
+	n := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&s.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&s.maxInFlight, max, n) {
+			break
+		}
+	}
+	// A slow Azure AD gives racing refreshes time to overlap.
+	time.Sleep(20 * time.Millisecond)
+
+	token := fmt.Sprintf("token-%d", atomic.AddInt32(&s.issued, 1))
+	expires := time.Now().Add(tokenStubLifetime)
+	s.mu.Lock()
+	s.expires[token] = expires
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, stubToken(token, expires))
+}
+
+func stubToken(accessToken string, expires time.Time) adal.Token {
+	return adal.Token{
+		AccessToken: accessToken,
+		ExpiresIn:   strconv.Itoa(int(time.Until(expires).Seconds())),
+		ExpiresOn:   strconv.FormatInt(expires.Unix(), 10),
+		NotBefore:   strconv.FormatInt(time.Now().Unix(), 10),
+		Resource:    "https://vault.azure.net",
+		Type:        "Bearer",
+	}
+}
+
+// serverForEveryHost returns a client sending every request to server, whatever its host, so requests
+// for login.microsoftonline.com and *.vault.azure.net go through the code that checks those names.
+func serverForEveryHost(server *httptest.Server) *http.Client {
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.ServerName = "example.com"
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	return &http.Client{Transport: transport}
+}
+
+// TestConcurrentReadsWhileTokenExpires reads secrets from many goroutines, through several Clients sharing
+// one authorizer, while the token expires and is refreshed under them. Run it with -race.
+func TestConcurrentReadsWhileTokenExpires(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for several tokens to expire")
+	}
+	for _, tt := range []struct {
+		name        string
+		cachedToken bool
+	}{
+		// The token is requested by New and refreshed in place by syncRefresher.
+		{"fresh token", false},
+		// The cached token is replaced by cachedTokenRefresher, then refreshed by syncRefresher.
+		{"cached token", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTokenStub(t)
+			server := httptest.NewUnstartedServer(stub)
+			server.TLS = &tls.Config{}
+			server.StartTLS()
+			defer server.Close()
+
+			cfg := Config{
+				TenantID:         "tenant",
+				ClientID:         "stress-" + strings.ReplaceAll(tt.name, " ", "-"),
+				ClientSecret:     "secret",
+				TokenCacheDir:    t.TempDir(),
+				TokenRefreshSkew: tokenStubLifetime - time.Second,
+				MaxRetries:       -1,
+				HTTPClient:       serverForEveryHost(server),
+			}
+			if tt.cachedToken {
+				expires := time.Now().Add(tokenStubLifetime)
+				stub.expires["cached-token"] = expires
+				path := filepath.Join(cfg.TokenCacheDir, cfg.ClientID+".token.json")
+				if err := saveCachedToken(path, "", stubToken("cached-token", expires)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			clients := make([]*Client, 4)
+			for i := range clients {
+				client, err := New(cfg)
+				if err != nil {
+					t.Fatalf("New: %v", err)
+				}
+				clients[i] = client
+			}
+
+			const goroutines = 32
+			deadline := time.Now().Add(3 * tokenStubLifetime)
+			var reads, failures int32
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func(client *Client) {
+					defer wg.Done()
+					for time.Now().Before(deadline) {
+						value, err := client.GetSecret(context.Background(), "https://myvault.vault.azure.net", "Password", "")
+						if err != nil || value != "hunter2" {
+							if atomic.AddInt32(&failures, 1) <= 3 {
+								t.Errorf("GetSecret = %q, %v", value, err)
+							}
+							continue
+						}
+						atomic.AddInt32(&reads, 1)
+					}
+				}(clients[g%len(clients)])
+			}
+			wg.Wait()
+
+			// Without serialized refreshes every goroutine holding an expiring token requests its own.
+			issued := atomic.LoadInt32(&stub.issued)
+			if max := int32(3*tokenStubLifetime/time.Second) + 2; issued > max {
+				t.Errorf("%d tokens were issued for %d reads, want at most %d", issued, reads, max)
+			}
+			if issued < 2 {
+				t.Errorf("%d token(s) were issued, want the token to have been refreshed during the test", issued)
+			}
+			if n := atomic.LoadInt32(&stub.maxInFlight); n > 1 {
+				t.Errorf("%d token requests were in flight at once, want refreshes to happen one at a time", n)
+			}
+			if reads == 0 {
+				t.Error("no secret was read")
+			}
+		})
+	}
+}
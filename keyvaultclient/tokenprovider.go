@@ -0,0 +1,34 @@
+package keyvaultclient
+
+import (
+	"context"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// TokenProvider supplies the authorizer a Client signs its Key Vault requests with. New uses a
+// ConfigTokenProvider; tests can pass NewWithTokenProvider a fake returning, say, autorest.NullAuthorizer{}
+// to exercise a Client against a stub server without Azure AD.
+type TokenProvider interface {
+	Authorizer() (autorest.Authorizer, error)
+}
+
+// ConfigTokenProvider authenticates as its Config describes: with a Service Principal's secret,
+// certificate or federated token, a managed identity, the Azure CLI or a device code, caching and
+// refreshing tokens as New always has.
+type ConfigTokenProvider struct {
+	Config Config
+
+	// ctx traces the authentication as part of the caller's span, when NewContext created the provider.
+	ctx context.Context
+}
+
+// Authorizer implements TokenProvider. It acquires, or loads from the cache, the token for the configured
+// cloud's Key Vault resource up front, so bad credentials are reported here rather than by the first request.
+func (p *ConfigTokenProvider) Authorizer() (autorest.Authorizer, error) {
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return newVaultAuthorizer(ctx, p.Config)
+}
@@ -0,0 +1,57 @@
+package keyvaultclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// tracer creates the spans around Key Vault and Azure AD calls. It is a no-op unless the application
+// installs an OpenTelemetry TracerProvider with otel.SetTracerProvider.
+var tracer = otel.Tracer("github.com/stevebargelt/goAzureKeyVault/keyvaultclient")
+
+// startVaultSpan starts a span for an operation against a vault, recording the vault host. Secret values
+// must never be passed as attributes.
+func startVaultSpan(ctx context.Context, name string, vaultBaseURL string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if u, err := url.Parse(vaultBaseURL); err == nil {
+		attrs = append(attrs, attribute.String("keyvault.host", u.Hostname()))
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingSender puts each token request adal makes in a span. adal requests tokens through its sender
+// both for the first token and for the refreshes the BearerAuthorizer triggers near expiry. Azure AD's
+// Date header is checked for clock skew on the way.
+type tracingSender struct {
+	sender adal.Sender
+}
+
+func (s tracingSender) Do(r *http.Request) (*http.Response, error) {
+	_, span := tracer.Start(r.Context(), "RefreshToken", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("aad.host", r.URL.Hostname())))
+	resp, err := s.sender.Do(r)
+	if err == nil {
+		checkClockSkew(resp)
+	}
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	endSpan(span, err)
+	return resp, err
+}
@@ -0,0 +1,120 @@
+package keyvaultclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Connection timeouts used when the matching TransportOptions field is zero.
+const (
+	DefaultDialTimeout         = 10 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// TransportOptions controls the connections of the client NewHTTPClientWithOptions builds.
+type TransportOptions struct {
+	// CABundlePath, when set, names a PEM file of certificates trusted in addition to the system's roots.
+	CABundlePath string
+	// DialTimeout bounds connecting to Key Vault, Azure AD or the proxy, so an unreachable host fails fast
+	// instead of hanging until the request deadline. Zero means DefaultDialTimeout.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake once connected. Zero means DefaultTLSHandshakeTimeout.
+	TLSHandshakeTimeout time.Duration
+	// IdleConnTimeout is how long an idle kept-alive connection is held for reuse. Zero means
+	// DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration
+	// InsecureSkipVerify turns off TLS certificate verification, as NewInsecureHTTPClient describes.
+	InsecureSkipVerify bool
+}
+
+// NewHTTPClient returns a client for Config.HTTPClient that uses the proxy named by HTTPS_PROXY, HTTP_PROXY
+// and NO_PROXY and, when caBundlePath is set, trusts the PEM certificates in that file in addition to the
+// system's roots, as needed behind a TLS-inspecting egress proxy.
+func NewHTTPClient(caBundlePath string) (*http.Client, error) {
+	return NewHTTPClientWithOptions(TransportOptions{CABundlePath: caBundlePath})
+}
+
+// NewHTTPClientWithOptions is NewHTTPClient with the connection timeouts opts sets. Config.HTTPClient is
+// used for Azure AD token requests as well as for Key Vault, so the timeouts apply to both.
+func NewHTTPClientWithOptions(opts TransportOptions) (*http.Client, error) {
+	transport := newTransport(opts)
+	if opts.CABundlePath != "" {
+		if opts.InsecureSkipVerify {
+			return nil, errors.New("a CA bundle is pointless with certificate verification turned off")
+		}
+		tlsConfig, err := caBundleTLSConfig(opts.CABundlePath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if opts.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		return &http.Client{Transport: insecureTransport{transport: transport}}, nil
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// newTransport returns Go's default transport, honoring the proxy environment variables, with the
+// timeouts in opts.
+func newTransport(opts TransportOptions) *http.Transport {
+	dialTimeout := opts.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	if transport.TLSHandshakeTimeout <= 0 {
+		transport.TLSHandshakeTimeout = DefaultTLSHandshakeTimeout
+	}
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	return transport
+}
+
+// caBundleTLSConfig returns a TLS configuration trusting the system roots plus the certificates in a PEM file.
+func caBundleTLSConfig(path string) (*tls.Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the CA bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("the CA bundle holds no PEM certificates")
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// NewInsecureHTTPClient returns a client for Config.HTTPClient that doesn't verify TLS certificates at all,
+// for tests against a local Key Vault emulator with a self-signed certificate. Anyone on the network path
+// can read and change its traffic, credentials included, so every request it sends is logged as a warning.
+// Never use it against Azure.
+func NewInsecureHTTPClient() *http.Client {
+	client, _ := NewHTTPClientWithOptions(TransportOptions{InsecureSkipVerify: true})
+	return client
+}
+
+// insecureTransport warns about each request that goes out without certificate verification.
+type insecureTransport struct {
+	transport http.RoundTripper
+}
+
+func (t insecureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	log.Warnf("INSECURE: TLS certificate verification is disabled. host=%s", r.URL.Host)
+	return t.transport.RoundTrip(r)
+}
@@ -2,21 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
-	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
-	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/subosito/gotenv"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
 )
 
 var (
@@ -24,153 +35,1047 @@ var (
 	vaultBaseURL          string
 	userSecretName        string
 	userSecretVersion     string
+	userVaultURL          string
 	passwordSecretName    string
 	passwordSecretVersion string
+	passwordVaultURL      string
 	subscriptionID        string
 	tenantID              string
 	clientID              string
 	clientSecret          string
+	clientCertPath        string
+	clientCertPassword    string
+	federatedTokenFile    string
+	authMethod            string
+	msiClientID           string
+	maxConcurrency        int
+	requestTimeout        time.Duration
+	operationDeadline     time.Duration
+	defaultSecretVersion  string
+	maxRetries            int
+	secretCacheTTL        time.Duration
+	strictExpiry          bool
+	strictMode            bool
+	requestsPerSecond     float64
+	breakerThreshold      int
+	breakerWindow         time.Duration
+	breakerCooldown       time.Duration
+	environment           azure.Environment
+	tokenEndpoint         string
+	httpClient            *http.Client
+	auditLogPath          string
+	disableTokenCache     bool
+	tokenCacheDir         string
+	tokenCacheKey         string
+	tokenRefreshSkew      time.Duration
+	outputFormat          string
+	pollInterval          time.Duration
+	reloadCommand         string
+	appConfigEndpoint     string
+	appConfigKeyFilter    string
+	appConfigLabel        string
+	quiet                 bool
+	outputFileMode        os.FileMode
+)
 
-	oauthConfig *adal.OAuthConfig
+// Command line flags. Each one overrides the environment variable named in its usage when both are set.
+var (
+	vaultURLFlag      = flag.String("vault-url", "", "Key Vault base URL (overrides VAULT_BASE_URL)")
+	tenantIDFlag      = flag.String("tenant-id", "", "Azure AD tenant ID (overrides AZ_TENANT_ID)")
+	clientIDFlag      = flag.String("client-id", "", "Service Principal application ID (overrides AZ_CLIENT_ID)")
+	authMethodFlag    = flag.String("auth-method", "", "secret, cert, federated, devicecode, msi or cli (overrides AUTH_METHOD)")
+	secretFlag        = flag.String("secret", "", "name (or name#version) of a single secret to fetch instead of the USER_/PASSWORD_ secrets")
+	secretVersionFlag = flag.String("secret-version", "", "version of -secret to fetch; empty means the current version")
+	secretsFileFlag   = flag.String("secrets-file", "", "file listing secret names to fetch, one per line or as a JSON array")
+	configFlag        = flag.String("config", "", "YAML or JSON config file; flags, environment variables and .env override it")
+	watchFlag         = flag.Bool("watch", false, "keep polling the secrets every POLL_INTERVAL and rewrite -out when one changes")
+	outFlag           = flag.String("out", "", "file the secrets are written to instead of stdout (required by -watch)")
+	templateFlag      = flag.String("template", "", "text/template file to render the secrets through, instead of OUTPUT_FORMAT")
+	decodeFlag        = flag.String("decode", "", "decode every secret's value before writing it; only base64 is supported")
+	insecurePermsFlag = flag.Bool("allow-insecure-perms", false, "allow an OUTPUT_FILE_MODE that lets other users read or write the files")
+	quietFlag         = flag.Bool("quiet", false, "print only the secret values, without the banners and labels (overrides QUIET)")
+	noCacheFlag       = flag.Bool("no-cache", false, "don't read or write the token cache (overrides DISABLE_TOKEN_CACHE)")
+	versionFlag       = flag.Bool("version", false, "print the version, commit and build date and exit")
+	timingsFlag       = flag.Bool("timings", false, "print how long each operation took, as a summary on exit")
+	dryRunFlag        = flag.Bool("dry-run", false, "check the configuration and print what would be fetched, without calling Azure")
 )
 
 func init() {
 
 	err := loadEnvVars()
 	if err != nil {
-		os.Exit(1)
+		fatalf(exitConfig, "Could not load .env: %v", err)
 	}
 
-	log.SetFormatter(&log.JSONFormatter{})
-	log.SetOutput(os.Stdout)
+	setEnvPrefix()
+	setLogFormat()
+	setLogOutput()
 	setLogLevel()
 }
 
 func main() {
 
+	flag.Usage = usage
+	flag.Parse()
+
+	if *versionFlag {
+		printVersion(os.Stdout)
+		return
+	}
+
+	var cfg fileConfig
+	if *configFlag != "" {
+		var err error
+		cfg, err = loadConfigFile(*configFlag)
+		if err != nil {
+			fatalf(exitConfig, "Could not load config: %v", err)
+		}
+	}
+
 	err := parseArgs()
 	if err != nil {
-		log.Fatalf("failed to parse args: %s\n", err)
+		fatalf(exitConfig, "failed to parse args: %s\n", err)
+	}
+
+	if *templateFlag != "" {
+		outputTemplate, err = loadTemplate(*templateFlag)
+		if err != nil {
+			fatalf(exitConfig, "Could not load template: %v", err)
+		}
+	}
+	outputSinks, err = cfg.outputSinks()
+	if err != nil {
+		fatalf(exitConfig, "Invalid outputs in %s: %v", *configFlag, err)
+	}
+	base64Secrets = cfg.base64Secrets()
+	jsonPaths = cfg.jsonPaths()
+	if len(outputSinks) > 0 && (*outFlag != "" || *templateFlag != "" || *watchFlag) {
+		fatalf(exitConfig, "The outputs in %s can't be combined with -out, -template or -watch", *configFlag)
+	}
+
+	if *dryRunFlag {
+		runDryRun(cfg)
+		return
+	}
+
+	if addr := getenv("METRICS_ADDR"); addr != "" {
+		serveMetrics(addr)
+	}
+	if *timingsFlag {
+		keyvaultclient.EnableTimings()
+		defer printTimings()
+	}
+	if getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		defer startTracing()()
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "get":
+			runGet(args[1:])
+		case "list":
+			runList(args[1:])
+		case "set":
+			runSet(args[1:])
+		case "rotate":
+			runRotate(args[1:])
+		case "versions":
+			runVersions(args[1:])
+		case "secret":
+			runSecret(args[1:])
+		case "delete", "recover", "purge":
+			runDelete(args[0], args[1:])
+		case "cert":
+			runCert(args[1:])
+		case "key":
+			runKey(args[1:])
+		case "backup":
+			runBackup(args[1:])
+		case "restore":
+			runRestore(args[1:])
+		case "import":
+			runImport(args[1:])
+		case "healthcheck":
+			runHealthcheck(args[1:])
+		case "login":
+			runLogin(args[1:])
+		default:
+			fatalf(exitConfig, "unknown command %q", args[0])
+		}
+		return
 	}
 
-	fmt.Println("Getting Key Vault")
-	cli, err := getKeysClient()
+	if *watchFlag {
+		refs, envVars := watchedSecrets(cfg)
+		runWatch(refs, envVars, *outFlag)
+		return
+	}
+
+	if *secretFlag != "" {
+		runGetOne(*secretFlag, *secretVersionFlag)
+		return
+	}
+
+	if *secretsFileFlag != "" {
+		runSecretsFile(*secretsFileFlag)
+		return
+	}
+
+	if len(cfg.Secrets) > 0 {
+		refs, envVars := cfg.secretRefs(vaultBaseURL)
+		runSecretRefs(*configFlag, refs, envVars)
+		return
+	}
+
+	if appConfigEndpoint != "" {
+		refs, envVars := appConfigSecrets()
+		runSecretRefs(appConfigEndpoint, refs, envVars)
+		return
+	}
+
+	err = parseSecretArgs()
 	if err != nil {
-		log.Fatalf("Could not get a Key Vault Client. %v", err)
+		fatalf(exitConfig, "failed to parse args: %s\n", err)
 	}
 
+	if customOutput() {
+		runGetConfigured()
+		return
+	}
+
+	banner("Getting Key Vault")
+	client := newClient()
+
 	ctx := context.Background()
 
-	username, err := getSecret(ctx, &cli, vaultBaseURL, userSecretName, userSecretVersion)
+	code := 0
+	username, err := client.GetSecret(ctx, userVaultURL, userSecretName, userSecretVersion)
 	if err != nil {
 		log.Warnf("Error when trying to retrieve secret %s. Error: %v", userSecretName, err.Error())
+		code = exitCode(err)
 	}
-	fmt.Printf("Username Value= %s\n", username)
+	printValue("Username Value= ", username)
 
 	//If we omit the secret version we get the current (latest) secret
-	fmt.Println("--- Password with no version set (current) ---")
-	password, err := getSecret(ctx, &cli, vaultBaseURL, passwordSecretName, "")
+	banner("--- Password with no version set (current) ---")
+	password, err := client.GetSecret(ctx, passwordVaultURL, passwordSecretName, "")
 	if err != nil {
 		log.Warnf("Error when trying to retrieve secret %s. Error: %v", passwordSecretName, err.Error())
+		if code == 0 {
+			code = exitCode(err)
+		}
 	}
-	fmt.Printf("  Password Value= %s\n", password)
+	printValue("  Password Value= ", password)
 
 	//Using the secret version we can access specific versions of the secret (older, etc.)
-	fmt.Printf("--- Password version %s ---\n", passwordSecretVersion)
-	password, err = getSecret(ctx, &cli, vaultBaseURL, passwordSecretName, passwordSecretVersion)
+	banner(fmt.Sprintf("--- Password version %s ---", passwordSecretVersion))
+	password, err = client.GetSecret(ctx, passwordVaultURL, passwordSecretName, passwordSecretVersion)
 	if err != nil {
 		log.Warnf("Error when trying to retrieve secret %s. Error: %v", passwordSecretName, err.Error())
+		if code == 0 {
+			code = exitCode(err)
+		}
+	}
+	printValue("  Password Value= ", password)
+	if code != 0 {
+		exit(code)
 	}
-	fmt.Printf("  Password Value= %s\n", password)
 }
 
-func getSecret(ctx context.Context, cli *keyvault.BaseClient, vaultBaseURL string, secretName string, secretVersion string) (string, error) {
-	defer timeTrack(time.Now(), "getSecret")
-	secretBundle, err := cli.GetSecret(ctx, vaultBaseURL, secretName, secretVersion)
+// usage prints the flags and subcommands understood by the tool.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  (none)")
+	fmt.Fprintln(out, "    \tfetch the secret named by -secret, those listed in -secrets-file or -config, or the USER_ and PASSWORD_ secrets")
+	fmt.Fprintln(out, "  get [-version version] <name>")
+	fmt.Fprintln(out, "    \tprint a secret's value and nothing else, for capturing it with $(...)")
+	fmt.Fprintln(out, "  list [-include-disabled] [-tag key=value] [-prefix prefix] [-since time] [-max n]")
+	fmt.Fprintln(out, "    \tprint the name of every secret in the vault")
+	fmt.Fprintln(out, "  versions <name>")
+	fmt.Fprintln(out, "    \tprint every version of a secret, newest first")
+	fmt.Fprintln(out, "  secret info [-version version] <name>")
+	fmt.Fprintln(out, "    \tprint a secret's version, content type, tags and dates, never its value")
+	fmt.Fprintln(out, "  secret diff [-show-values] <name> <version1> <version2>")
+	fmt.Fprintln(out, "    \tsay whether two versions of a secret differ, and in which lines; exit 1 if they do")
+	fmt.Fprintln(out, "  secret save [-version version] [-out file] <name>")
+	fmt.Fprintln(out, "    \tsave a secret to a file named and decoded as its content type says")
+	fmt.Fprintln(out, "  secret deleted list")
+	fmt.Fprintln(out, "    \tprint the soft-deleted secrets, when they were deleted and when they will be purged")
+	fmt.Fprintln(out, "  set [-content-type type] [-file file] [-tag key=value] [-not-before time] [-expires time] <name> [<value>]")
+	fmt.Fprintln(out, "    \tcreate a new version of a secret, set to value or to the contents of -file")
+	fmt.Fprintln(out, "  rotate [-length n] [-charset chars] [-disable-previous] <name>")
+	fmt.Fprintln(out, "    \tcreate a new version of a secret with a random password")
+	fmt.Fprintln(out, "  delete|recover|purge <name>")
+	fmt.Fprintln(out, "    \tdelete a secret, or recover or purge a soft-deleted one")
+	fmt.Fprintln(out, "  backup [-dir directory] <name>")
+	fmt.Fprintln(out, "    \tback up every version of a secret to <name>-<timestamp>.kvbackup")
+	fmt.Fprintln(out, "  restore <file>")
+	fmt.Fprintln(out, "    \trestore a secret from a backup file")
+	fmt.Fprintln(out, "  import [-prefix prefix] [-dry-run] <file>")
+	fmt.Fprintln(out, "    \tupload every KEY=VALUE pair of a .env file as a secret")
+	fmt.Fprintln(out, "  cert get [-version version] [-out file] [-cert-only | -pfx [-legacy-pfx]] <name>")
+	fmt.Fprintln(out, "    \twrite a certificate, its chain and its private key to a PEM or PKCS#12 file")
+	fmt.Fprintln(out, "  cert expiring [-days n] [-include-disabled]")
+	fmt.Fprintln(out, "    \tprint the certificates expiring within n days (default 30); exit 1 if there are any")
+	fmt.Fprintln(out, "  key list")
+	fmt.Fprintln(out, "    \tprint the id of every key in the vault")
+	fmt.Fprintln(out, "  login [-force]")
+	fmt.Fprintln(out, "    \tauthenticate and print when the token expires; -force replaces the cached token with a new one")
+	fmt.Fprintln(out, "  healthcheck")
+	fmt.Fprintln(out, "    \tauthenticate and read HEALTHCHECK_SECRET, or list one secret; exit non-zero if that fails")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+	fmt.Fprintln(out, "\nAny setting not given as a flag is read from the environment or .env.")
+}
+
+// runGetOne fetches the single secret named on the command line.
+func runGetOne(secretName string, secretVersion string) {
+	client := newClient()
+
+	secret, err := client.GetSecretDetails(context.Background(), vaultBaseURL, secretName, secretVersion)
 	if err != nil {
-		return "", err
+		fatalf(exitCode(err), "Error when trying to retrieve secret %s. Error: %v", secretName, err)
 	}
-	return *secretBundle.Value, nil
+	if customOutput() {
+		if err := writeOutputs([]keyvaultclient.Secret{secret}, nil); err != nil {
+			fatalf(exitFailure, "Could not write secrets: %v", err)
+		}
+		return
+	}
+	printValue(secretName+" Value= ", string(secret.Value))
 }
 
-func getKeysClient() (keyvault.BaseClient, error) {
-	vmClient := keyvault.New()
-	authorizer, err := getKeyvaultAuthorizer()
+// runGet implements the get subcommand, printing one secret's value followed by a newline as the only
+// output on stdout, so it can be captured with $(...). Logs go to stderr even with LOG_OUTPUT=stdout.
+func runGet(args []string) {
+	log.SetOutput(os.Stderr)
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	version := flags.String("version", "", "secret version; empty means the current version")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: get [-version version] <name>")
+	}
+	explicit := *version != "" || strings.Contains(flags.Arg(0), "#")
+	name, secretVersion, err := resolveSecretVersion("name", flags.Arg(0), *version)
+	if err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+
+	client := newClient()
+
+	value, err := client.GetSecret(context.Background(), vaultBaseURL, name, versionOrDefault(secretVersion, explicit))
 	if err != nil {
-		return vmClient, err
+		fatalf(exitCode(err), "Could not get secret %s: %v", name, err)
 	}
-	vmClient.Authorizer = authorizer
-	return vmClient, nil
+	fmt.Println(value)
 }
 
-func getKeyvaultAuthorizer() (authorizer autorest.Authorizer, err error) {
+// banner prints an informational line, unless -quiet is set.
+func banner(line string) {
+	if !quiet {
+		fmt.Println(line)
+	}
+}
+
+// printValue prints a secret value after its label, or alone with -quiet, so stdout holds only data.
+func printValue(label string, value string) {
+	if quiet {
+		label = ""
+	}
+	fmt.Printf("%s%s\n", label, value)
+}
+
+// runGetConfigured writes the USER_ and PASSWORD_ secrets, each at its configured version, in a
+// machine readable OUTPUT_FORMAT.
+func runGetConfigured() {
+	fetchAndWrite([]keyvaultclient.SecretRef{
+		{VaultBaseURL: userVaultURL, Name: userSecretName, Version: userSecretVersion},
+		{VaultBaseURL: passwordVaultURL, Name: passwordSecretName, Version: passwordSecretVersion},
+	}, nil)
+}
 
-	oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, tenantID)
+// runSecretsFile fetches every secret listed in the secrets file concurrently and prints them as name=value lines.
+func runSecretsFile(path string) {
+	refs, err := readSecretRefs(path, vaultBaseURL)
 	if err != nil {
-		return nil, fmt.Errorf("Could not create oauthConfig: %v", err.Error())
+		fatalf(exitConfig, "Could not read secrets file: %v", err)
+	}
+	runSecretRefs(path, refs, nil)
+}
+
+// runSecretRefs fetches secrets listed in the file at path concurrently and prints them.
+func runSecretRefs(path string, refs []keyvaultclient.SecretRef, envVars map[string]string) {
+	for _, ref := range refs {
+		if err := validateVaultURL("vaultBaseURL of "+ref.Name, ref.VaultBaseURL, environment); err != nil {
+			fatalf(exitConfig, "Invalid vault in %s: %v", path, err)
+		}
+	}
+	if writesDotenv() {
+		if err := checkEnvVars(refs, envVars); err != nil {
+			fatalf(exitConfig, "Invalid variable names in %s: %v", path, err)
+		}
 	}
-	updatedAuthorizeEndpoint, err := url.Parse("https://login.windows.net/" + tenantID + "/oauth2/token")
+
+	fetchAndWrite(refs, envVars)
+}
+
+// fetchAndWrite fetches refs with keyvaultclient.Run and writes the result as OUTPUT_FORMAT says.
+func fetchAndWrite(refs []keyvaultclient.SecretRef, envVars map[string]string) {
+	result, err := keyvaultclient.Run(context.Background(), keyvaultclient.RunConfig{Config: auditedClientConfig(), Secrets: refs})
+	if result == nil {
+		fatalf(exitCode(err), "Could not get a Key Vault Client. %v", err)
+	}
+	code := 0
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		// Every failure is reported together; the first, by secret, sets the exit code.
+		failures := joined.Unwrap()
+		log.Errorf("Could not get %d of %d secret(s):\n%v", len(failures), len(refs), err)
+		code = exitCode(failures[0])
+		if strictMode {
+			fatalf(code, "STRICT_MODE is set, so nothing was written")
+		}
+	} else if err != nil {
+		fatalf(exitCode(err), "Could not get secrets: %v", err)
+	}
+	if err := writeOutputs(result.Details, envVars); err != nil {
+		fatalf(exitFailure, "Could not write secrets: %v", err)
+	}
+	// Unless STRICT_MODE is set, the secrets that could be read are still written, but a run missing any
+	// of them fails.
+	if code != 0 {
+		exit(code)
+	}
+}
+
+// runList implements the list subcommand, printing the name of every secret in the vault one per line.
+func runList(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	includeDisabled := flags.Bool("include-disabled", false, "include secrets whose Enabled attribute is false")
+	tags := tagFlag{}
+	flags.Var(tags, "tag", "only list secrets with this key=value tag (repeatable; all must match)")
+	maxResults := flags.Int("max", 0, "stop after listing this many secrets (0 lists all)")
+	prefix := flags.String("prefix", "", "only list secrets whose name starts with this (case-insensitive)")
+	since := flags.String("since", "", "only list secrets created or updated after this RFC3339 time")
+	flags.Parse(args)
+
+	opts := keyvaultclient.ListOptions{IncludeDisabled: *includeDisabled, Tags: tags, MaxResults: *maxResults, NamePrefix: *prefix}
+	changedSince, err := parseOptionalTime(*since)
 	if err != nil {
-		return nil, fmt.Errorf("Could not parse the Authorize Endpoint URL: %v", err.Error())
+		fatalf(exitConfig, "Invalid -since: %v", err)
+	}
+	if changedSince != nil {
+		opts.ChangedSince = *changedSince
 	}
 
-	oauthConfig.AuthorizeEndpoint = *updatedAuthorizeEndpoint
+	client := newClient()
 
-	cachePath := filepath.Join("cache", fmt.Sprintf("%s.token.json", clientID))
-	rawToken, err := tryLoadCachedToken(cachePath)
+	err = client.ListSecretsIter(context.Background(), vaultBaseURL, opts, func(id string) bool {
+		fmt.Println(keyvaultclient.SecretNameFromID(id))
+		return true
+	})
 	if err != nil {
-		rawToken = nil
-		log.Warnf("Could not load Raw Token from file: %v", err.Error)
+		fatalf(exitCode(err), "Could not list secrets in %s: %v", vaultBaseURL, err)
 	}
+}
+
+// runVersions implements the versions subcommand, printing every version of a secret newest first.
+func runVersions(args []string) {
+	if len(args) != 1 {
+		fatalf(exitConfig, "usage: versions <name>")
+	}
+	name := args[0]
+
+	client := newClient()
+
+	versions, err := client.ListSecretVersions(context.Background(), vaultBaseURL, name)
+	if err != nil {
+		fatalf(exitCode(err), "Could not list versions of secret %s: %v", name, err)
+	}
+	for _, v := range versions {
+		fmt.Printf("%s\tcreated=%s\tupdated=%s\tenabled=%t\n", v.Version, v.Created.Format(time.RFC3339), v.Updated.Format(time.RFC3339), v.Enabled)
+	}
+}
+
+// runSecret implements the secret subcommand: info prints a secret's metadata as a table, or as JSON with
+// OUTPUT_FORMAT=json, diff compares two of its versions, save writes it to a file and deleted list shows
+// the soft-deleted secrets.
+func runSecret(args []string) {
+	switch {
+	case len(args) > 0 && args[0] == "info":
+		runSecretInfo(args[1:])
+	case len(args) > 0 && args[0] == "diff":
+		runSecretDiff(args[1:])
+	case len(args) > 0 && args[0] == "save":
+		runSecretSave(args[1:])
+	case len(args) == 2 && args[0] == "deleted" && args[1] == "list":
+		runDeletedList()
+	default:
+		fatalf(exitConfig, "usage: secret info [-version version] <name> | secret diff [-show-values] <name> <version1> <version2> | secret save [-version version] [-out file] <name> | secret deleted list")
+	}
+}
+
+// runSecretInfo implements secret info, printing a secret's metadata without its value.
+func runSecretInfo(args []string) {
+	flags := flag.NewFlagSet("secret info", flag.ExitOnError)
+	version := flags.String("version", "", "secret version; empty means the current version")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: secret info [-version version] <name>")
+	}
+	name, v, err := resolveSecretVersion("secret", flags.Arg(0), *version)
+	if err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+
+	client := newClient()
+
+	info, err := client.GetSecretInfo(context.Background(), vaultBaseURL, name, v)
+	if err != nil {
+		fatalf(exitCode(err), "Could not get secret %s: %v", name, err)
+	}
+	if outputFormat == "json" {
+		err = writeSecretInfoJSON(os.Stdout, info)
+	} else {
+		err = writeSecretInfo(os.Stdout, info)
+	}
+	if err != nil {
+		fatalf(exitFailure, "Could not write secret info: %v", err)
+	}
+}
 
-	var spt *adal.ServicePrincipalToken
-	if rawToken != nil && !rawToken.IsExpired() {
-		defer timeTrack(time.Now(), "NewServicePrincipalTokenFromManualToken")
-		spt, err = adal.NewServicePrincipalTokenFromManualToken(*oauthConfig, clientID, "https://vault.azure.net", *rawToken)
+// runDeletedList implements secret deleted list, printing the soft-deleted secrets that can still be
+// recovered or purged.
+func runDeletedList() {
+	client := newClient()
+
+	deleted, err := client.ListDeletedSecrets(context.Background(), vaultBaseURL)
+	if err != nil {
+		fatalf(exitCode(err), "Could not list deleted secrets in %s: %v", vaultBaseURL, err)
+	}
+	if outputFormat == "json" {
+		err = writeDeletedSecretsJSON(os.Stdout, deleted)
+	} else {
+		err = writeDeletedSecrets(os.Stdout, deleted)
+	}
+	if err != nil {
+		fatalf(exitFailure, "Could not write deleted secrets: %v", err)
+	}
+}
+
+// runSet implements the set subcommand: set [flags] <name> <value>, or set [flags] -file <file> <name>.
+func runSet(args []string) {
+	flags := flag.NewFlagSet("set", flag.ExitOnError)
+	contentType := flags.String("content-type", "", "content type to record on the secret (default from -file's extension)")
+	file := flags.String("file", "", "set the secret to this file's contents, base64-encoded for binary content types")
+	notBefore := flags.String("not-before", "", "RFC3339 time before which the secret is not active")
+	expires := flags.String("expires", "", "RFC3339 time after which the secret expires")
+	tags := tagFlag{}
+	flags.Var(tags, "tag", "key=value tag to attach to the secret (repeatable)")
+	flags.Parse(args)
+	if *file != "" && flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: set [flags] -file <file> <name>")
+	} else if *file == "" && flags.NArg() != 2 {
+		fatalf(exitConfig, "usage: set [flags] <name> <value>")
+	}
+
+	opts := keyvaultclient.SecretOptions{ContentType: *contentType, Tags: tags}
+	value := flags.Arg(1)
+	if *file != "" {
+		data, err := ioutil.ReadFile(*file)
 		if err != nil {
-			return nil, err
+			fatalf(exitConfig, "Could not read %s: %v", *file, err)
 		}
-	} else {
-		defer timeTrack(time.Now(), "NewServicePrincipalToken")
-		spt, err = adal.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, "https://vault.azure.net")
+		if opts.ContentType == "" {
+			opts.ContentType = contentTypeForFile(*file, data)
+		}
+		value = secretFileValue(data, opts.ContentType)
+	}
+	var err error
+	opts.NotBefore, err = parseOptionalTime(*notBefore)
+	if err != nil {
+		fatalf(exitConfig, "Invalid -not-before: %v", err)
+	}
+	opts.Expires, err = parseOptionalTime(*expires)
+	if err != nil {
+		fatalf(exitConfig, "Invalid -expires: %v", err)
+	}
+
+	client := newClient()
+
+	name := flags.Arg(0)
+	version, err := client.SetSecret(context.Background(), vaultBaseURL, name, value, opts)
+	if err != nil {
+		fatalf(exitCode(err), "Could not set secret %s: %v", name, err)
+	}
+	fmt.Printf("Set %s version %s\n", name, version)
+}
+
+// runRotate implements the rotate subcommand, replacing a secret's value with a random password. The
+// password itself isn't printed.
+func runRotate(args []string) {
+	flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+	length := flags.Int("length", 32, "length of the generated password")
+	charset := flags.String("charset", "", "characters the password is drawn from (default letters, digits and punctuation)")
+	disablePrevious := flags.Bool("disable-previous", false, "disable the version being replaced")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: rotate [flags] <name>")
+	}
+	if *length <= 0 {
+		fatalf(exitConfig, "-length must be positive")
+	}
+	name := flags.Arg(0)
+
+	client := newClient()
+
+	gen := keyvaultclient.PasswordGenerator(*length, *charset)
+	version, previous, err := client.RotateSecret(context.Background(), vaultBaseURL, name, gen, keyvaultclient.RotateOptions{DisablePrevious: *disablePrevious})
+	if err != nil {
+		if version != "" {
+			fmt.Printf("Rotated %s to version %s\n", name, version)
+		}
+		fatalf(exitCode(err), "Could not rotate secret %s: %v", name, err)
+	}
+	switch {
+	case previous == "":
+		fmt.Printf("Created %s version %s\n", name, version)
+	case *disablePrevious:
+		fmt.Printf("Rotated %s to version %s, disabled version %s\n", name, version, previous)
+	default:
+		fmt.Printf("Rotated %s to version %s, replacing version %s\n", name, version, previous)
+	}
+}
+
+// parseOptionalTime parses an RFC3339 timestamp, returning nil for an empty string.
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// tagFlag collects repeated key=value flags into a map.
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	pairs := make([]string, 0, len(t))
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("tag %q must be in key=value form", value)
+	}
+	t[kv[0]] = kv[1]
+	return nil
+}
+
+// runDelete implements the delete, recover and purge subcommands, each of which takes a single secret name.
+func runDelete(command string, args []string) {
+	if len(args) != 1 {
+		fatalf(exitConfig, "usage: %s <name>", command)
+	}
+	name := args[0]
+
+	client := newClient()
+	ctx := context.Background()
+
+	switch command {
+	case "delete":
+		deleted, err := client.DeleteSecret(ctx, vaultBaseURL, name)
+		if err != nil {
+			fatalf(exitCode(err), "Could not delete secret %s: %v", name, err)
+		}
+		if deleted.ScheduledPurgeDate != nil {
+			fmt.Printf("Deleted %s, recoverable until %s\n", name, time.Time(*deleted.ScheduledPurgeDate).Format(time.RFC3339))
+		} else {
+			fmt.Printf("Deleted %s permanently\n", name)
+		}
+	case "recover":
+		err := client.RecoverDeletedSecret(ctx, vaultBaseURL, name)
+		if err != nil {
+			fatalf(exitCode(err), "Could not recover secret %s: %v", name, err)
+		}
+		fmt.Printf("Recovered %s\n", name)
+	case "purge":
+		err := client.PurgeDeletedSecret(ctx, vaultBaseURL, name)
 		if err != nil {
-			return nil, err
+			fatalf(exitCode(err), "Could not purge secret %s: %v", name, err)
 		}
+		fmt.Printf("Purged %s\n", name)
+	}
+}
+
+// runBackup implements the backup subcommand, writing a protected backup of a secret to a file
+// named after the secret and the time of the backup.
+func runBackup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory to write the backup file to")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: backup [-dir directory] <name>")
+	}
+	name := flags.Arg(0)
+
+	client := newClient()
+
+	backup, err := client.BackupSecret(context.Background(), vaultBaseURL, name)
+	if err != nil {
+		fatalf(exitCode(err), "Could not back up secret %s: %v", name, err)
+	}
+	path := filepath.Join(*dir, fmt.Sprintf("%s-%s.kvbackup", name, time.Now().UTC().Format("20060102T150405Z")))
+	err = writeSecretFile(path, backup)
+	if err != nil {
+		fatalf(exitFailure, "Could not write backup to %s: %v", path, err)
+	}
+	fmt.Printf("Backed up %s to %s\n", name, path)
+}
 
-		err = spt.Refresh()
+// runRestore implements the restore subcommand, recreating a secret from a file written by backup.
+func runRestore(args []string) {
+	if len(args) != 1 {
+		fatalf(exitConfig, "usage: restore <file>")
+	}
+	path := args[0]
+	backup, err := ioutil.ReadFile(path)
+	if err != nil {
+		fatalf(exitConfig, "Could not read backup: %v", err)
+	}
+
+	client := newClient()
+
+	name, err := client.RestoreSecret(context.Background(), vaultBaseURL, backup)
+	if err != nil {
+		fatalf(exitCode(err), "Could not restore secret from %s: %v", path, err)
+	}
+	fmt.Printf("Restored %s\n", name)
+}
+
+// runKey implements the key subcommand. Its only command, list, prints the id of every key in the vault.
+func runKey(args []string) {
+	if len(args) != 1 || args[0] != "list" {
+		fatalf(exitConfig, "usage: key list")
+	}
+
+	client := newClient()
+
+	keys, err := client.ListKeys(context.Background(), vaultBaseURL)
+	if err != nil {
+		fatalf(exitCode(err), "Could not list keys in %s: %v", vaultBaseURL, err)
+	}
+	for _, k := range keys {
+		fmt.Printf("%s\tenabled=%t\tmanaged=%t\n", k.ID, k.Enabled, k.Managed)
+	}
+}
+
+// runCert implements the cert subcommand: get writes a certificate to a PEM file, and expiring reports the
+// certificates close to expiry.
+func runCert(args []string) {
+	switch {
+	case len(args) > 0 && args[0] == "get":
+		runCertGet(args[1:])
+	case len(args) > 0 && args[0] == "expiring":
+		runCertExpiring(args[1:])
+	default:
+		fatalf(exitConfig, "usage: cert get [flags] <name> | cert expiring [-days n]")
+	}
+}
+
+// runCertGet implements cert get, writing a certificate to a PEM or PKCS#12 file.
+func runCertGet(args []string) {
+	flags := flag.NewFlagSet("cert get", flag.ExitOnError)
+	version := flags.String("version", "", "certificate version; empty means the current version")
+	out := flags.String("out", "", "file to write the PEM to (default <name>.pem, or <name>.pfx with -pfx)")
+	certOnly := flags.Bool("cert-only", false, "write only the certificate, without its private key")
+	pfx := flags.Bool("pfx", false, "write a PKCS#12 archive, encrypted with PFX_PASSWORD when set, instead of PEM")
+	legacyPFX := flags.Bool("legacy-pfx", false, "with -pfx, encrypt with 3DES for old Windows and Java versions")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatalf(exitConfig, "usage: cert get [flags] <name>")
+	}
+	if *pfx && *certOnly {
+		fatalf(exitConfig, "-pfx and -cert-only can't be combined")
+	}
+	if *legacyPFX && !*pfx {
+		fatalf(exitConfig, "-legacy-pfx needs -pfx")
+	}
+	name := flags.Arg(0)
+	path := *out
+	if path == "" && *pfx {
+		path = name + ".pfx"
+	} else if path == "" {
+		path = name + ".pem"
+	}
+
+	client := newClient()
+	ctx := context.Background()
+
+	var data []byte
+	if *certOnly {
+		cert, err := client.GetCertificate(ctx, vaultBaseURL, name, *version)
+		if err != nil {
+			fatalf(exitCode(err), "Could not get certificate %s: %v", name, err)
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Cer})
+	} else if *pfx {
+		// The password is read from the environment so it doesn't show up in the process list.
+		opts := keyvaultclient.PFXOptions{Password: getenv("PFX_PASSWORD"), Legacy: *legacyPFX}
+		var err error
+		data, err = client.GetCertificateAsPFX(ctx, vaultBaseURL, name, *version, opts)
 		if err != nil {
-			log.Warnf("Could not refresh token: %v", err.Error)
+			fatalf(exitCode(err), "Could not export certificate %s: %v", name, err)
 		}
-		adRawToken := spt.Token()
-		err = adal.SaveToken(cachePath, 0600, adRawToken)
+	} else {
+		var err error
+		data, err = client.ExportCertificatePEM(ctx, vaultBaseURL, name, *version)
 		if err != nil {
-			log.Warnf("Could not save token to cache path=%q: %v", cachePath, err.Error())
+			fatalf(exitCode(err), "Could not export certificate %s: %v", name, err)
+		}
+	}
+
+	// The file may hold a private key.
+	err := writeSecretFile(path, data)
+	if err != nil {
+		fatalf(exitFailure, "Could not write certificate to %s: %v", path, err)
+	}
+	fmt.Printf("Wrote %s to %s\n", name, path)
+}
+
+// runCertExpiring implements cert expiring, printing the enabled certificates that expire within -days,
+// or have already expired, soonest first. It exits 1 when there are any, so it can serve as a monitoring
+// check; OUTPUT_FORMAT=json prints them as JSON.
+func runCertExpiring(args []string) {
+	flags := flag.NewFlagSet("cert expiring", flag.ExitOnError)
+	days := flags.Int("days", 30, "report certificates expiring within this many days")
+	includeDisabled := flags.Bool("include-disabled", false, "include certificates whose Enabled attribute is false")
+	flags.Parse(args)
+	if flags.NArg() != 0 || *days < 0 {
+		fatalf(exitConfig, "usage: cert expiring [-days n] [-include-disabled]")
+	}
+
+	client := newClient()
+
+	certs, err := client.ListCertificates(context.Background(), vaultBaseURL)
+	if err != nil {
+		fatalf(exitCode(err), "Could not list certificates in %s: %v", vaultBaseURL, err)
+	}
+	now := time.Now()
+	deadline := now.AddDate(0, 0, *days)
+	var expiring []keyvaultclient.CertificateInfo
+	for _, cert := range certs {
+		if cert.Expires.IsZero() || cert.Expires.After(deadline) || (!cert.Enabled && !*includeDisabled) {
+			continue
 		}
-		log.Debugf("Saved token to cache. path=%q", cachePath)
+		expiring = append(expiring, cert)
+	}
+	sort.SliceStable(expiring, func(i, j int) bool { return expiring[i].Expires.Before(expiring[j].Expires) })
+
+	if outputFormat == "json" {
+		err = writeExpiringCertificatesJSON(os.Stdout, expiring, now)
+	} else if len(expiring) == 0 {
+		banner(fmt.Sprintf("None of the %d certificate(s) in %s expire within %d days", len(certs), vaultBaseURL, *days))
+	} else {
+		err = writeExpiringCertificates(os.Stdout, expiring, now)
+	}
+	if err != nil {
+		fatalf(exitFailure, "Could not write certificates: %v", err)
+	}
+	if len(expiring) > 0 {
+		exit(exitFailure)
+	}
+}
+
+// runLogin implements the login subcommand, authenticating as configured and printing when the token
+// expires. With -force the cached token is ignored and a fresh one requested and cached in its place, as
+// is needed after the client secret or certificate changes.
+func runLogin(args []string) {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	force := flags.Bool("force", false, "ignore the cached token and request a new one, replacing it in the cache")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		fatalf(exitConfig, "usage: login [-force]")
+	}
+
+	cfg := auditedClientConfig()
+	cfg.ForceTokenRefresh = *force
+	client, err := keyvaultclient.New(cfg)
+	if err != nil {
+		fatalf(exitCode(err), "Could not sign in: %v", err)
+	}
+	expires, err := client.TokenExpiresOn()
+	if err != nil {
+		fatalf(exitFailure, "Signed in, but %v", err)
+	}
+	fmt.Printf("Signed in; the token expires at %s (in %s)\n", expires.Format(time.RFC3339), time.Until(expires).Round(time.Minute))
+	switch {
+	case authMethod == "msi" || authMethod == "cli":
+		fmt.Printf("Tokens from AUTH_METHOD=%s aren't cached\n", authMethod)
+	case disableTokenCache:
+		fmt.Println("The token wasn't cached, as the token cache is disabled")
+	}
+}
+
+// runHealthcheck implements the healthcheck subcommand for readiness and liveness probes. It prints ok and
+// exits 0 once it has authenticated and read HEALTHCHECK_SECRET, or listed a secret when that isn't set;
+// otherwise it prints the reason to stderr and exits with the code for it, e.g. 3 when sign in failed.
+// REQUEST_TIMEOUT bounds the whole check.
+func runHealthcheck(args []string) {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	flags.Parse(args)
+
+	ctx := context.Background()
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
 	}
 
-	authorizer = autorest.NewBearerAuthorizer(spt)
-	return authorizer, nil
+	// Token requests can't be cancelled, so the check runs alongside the timeout rather than under it.
+	result := make(chan error, 1)
+	go func() {
+		result <- healthcheck(ctx, getenv("HEALTHCHECK_SECRET"))
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s: %w", requestTimeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		exit(exitCode(err))
+	}
+	fmt.Println("ok")
 }
 
-func tryLoadCachedToken(cachePath string) (*adal.Token, error) {
+func healthcheck(ctx context.Context, secretName string) error {
+	client, err := keyvaultclient.NewContext(ctx, auditedClientConfig())
+	if err != nil {
+		return fmt.Errorf("could not authenticate: %w", err)
+	}
+	if secretName != "" {
+		if _, err := client.GetSecret(ctx, vaultBaseURL, secretName, ""); err != nil {
+			return fmt.Errorf("could not read secret %s: %w", secretName, err)
+		}
+		return nil
+	}
+	if err := client.Ping(ctx, vaultBaseURL); err != nil {
+		return fmt.Errorf("could not reach %s: %w", vaultBaseURL, err)
+	}
+	return nil
+}
 
-	// Check for file not found so we can suppress the file not found error
-	// LoadToken doesn't discern and returns error either way
-	defer timeTrack(time.Now(), "tryLoadCachedToken")
-	if _, err := os.Stat(cachePath); err != nil {
-		if os.IsNotExist(err) {
-			log.Printf("Cache path does not exist. Path=%q", cachePath)
-			return nil, nil
+// printTimings writes the -timings summary to stderr, if anything was timed, followed by the token cache
+// hits and misses when the cache was read.
+func printTimings() {
+	summary := keyvaultclient.TimingSummary()
+	if len(summary) > 0 {
+		tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "OPERATION\tCOUNT\tMIN\tMAX\tP50\tP95")
+		for _, t := range summary {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", t.Operation, t.Count, t.Min, t.Max, t.P50, t.P95)
 		}
-		return nil, err
+		tw.Flush()
 	}
+	if lookups := keyvaultclient.TokenCacheSummary(); lookups.Total() > 0 {
+		fmt.Fprintf(os.Stderr, "Token cache: %d hit(s), %d missing, %d expired\n", lookups.Hit, lookups.Missing, lookups.Expired)
+	}
+}
 
-	token, err := adal.LoadToken(cachePath)
+// serveMetrics exposes the keyvaultclient operation latencies for Prometheus on addr under /metrics.
+func serveMetrics(addr string) {
+	err := keyvaultclient.EnableMetrics(prometheus.DefaultRegisterer)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to load token from file: %v", err)
+		fatalf(exitFailure, "Could not register metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		log.Errorf("Metrics server on %s stopped: %v", addr, err)
+	}()
+	log.Infof("Serving metrics. addr=%s", addr)
+}
+
+// startTracing exports OpenTelemetry spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT. The exporter
+// reads its other OTEL_ settings from the environment too. The returned function flushes pending spans.
+func startTracing() func() {
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		fatalf(exitConfig, "Could not create the OTLP trace exporter: %v", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Warnf("Could not flush traces: %v", err)
+		}
+	}
+}
+
+// newClient creates a Key Vault client from the parsed configuration, exiting if authorization fails.
+func newClient() *keyvaultclient.Client {
+	client, err := keyvaultclient.New(auditedClientConfig())
+	if err != nil {
+		fatalf(exitCode(err), "Could not get a Key Vault Client. %v", err)
+	}
+	return client
+}
+
+// auditedClientConfig is clientConfig with the audit log opened, exiting if it can't be.
+func auditedClientConfig() keyvaultclient.Config {
+	cfg := clientConfig()
+	if auditLogPath != "" {
+		f, err := os.OpenFile(auditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			fatalf(exitConfig, "Could not open audit log: %v", err)
+		}
+		cfg.AuditLog = f
+	}
+	return cfg
+}
+
+// clientConfig returns the keyvaultclient settings from the parsed configuration.
+func clientConfig() keyvaultclient.Config {
+	return keyvaultclient.Config{
+		AuthMethod:              authMethod,
+		TenantID:                tenantID,
+		ClientID:                clientID,
+		ClientSecret:            clientSecret,
+		ClientCertPath:          clientCertPath,
+		ClientCertPassword:      clientCertPassword,
+		FederatedTokenFile:      federatedTokenFile,
+		DisableTokenCache:       disableTokenCache,
+		TokenCacheDir:           tokenCacheDir,
+		TokenCacheKey:           tokenCacheKey,
+		TokenRefreshSkew:        tokenRefreshSkew,
+		Environment:             environment,
+		TokenEndpoint:           tokenEndpoint,
+		MSIClientID:             msiClientID,
+		MaxConcurrency:          maxConcurrency,
+		RequestTimeout:          requestTimeout,
+		OperationDeadline:       operationDeadline,
+		MaxRetries:              maxRetries,
+		SecretCacheTTL:          secretCacheTTL,
+		RequestsPerSecond:       requestsPerSecond,
+		CircuitBreakerThreshold: breakerThreshold,
+		CircuitBreakerWindow:    breakerWindow,
+		CircuitBreakerCooldown:  breakerCooldown,
+		StrictExpiry:            strictExpiry,
+		HTTPClient:              httpClient,
 	}
-	return token, nil
 }
 
 // LoadEnvVars loads environment variables.
@@ -184,38 +1089,414 @@ func loadEnvVars() error {
 
 func parseArgs() error {
 	var message string
-	vaultBaseURL = os.Getenv("VAULT_BASE_URL")
+	vaultBaseURL = flagOrEnv(*vaultURLFlag, "VAULT_BASE_URL")
 	if vaultBaseURL == "" {
 		message += fmt.Sprintln("VAULT_BASE_URL missing")
 	}
-	userSecretName = os.Getenv("USER_SECRET_NAME")
-	if userSecretName == "" {
-		message += fmt.Sprintln("USER_SECRET_NAME missing")
+	authMethod = flagOrEnv(*authMethodFlag, "AUTH_METHOD")
+	switch authMethod {
+	case "msi":
+		msiClientID = getenv("AZ_MSI_CLIENT_ID")
+	case "cli":
+		// The Service Principal is only a fallback for when the Azure CLI isn't installed.
+		tenantID = flagOrEnv(*tenantIDFlag, "AZ_TENANT_ID")
+		clientID = flagOrEnv(*clientIDFlag, "AZ_CLIENT_ID")
+		clientSecret = readClientSecret(&message)
+	case "", "secret":
+		tenantID = flagOrEnv(*tenantIDFlag, "AZ_TENANT_ID")
+		if tenantID == "" {
+			message += fmt.Sprintln("AZ_TENANT_ID missing")
+		}
+		clientID = flagOrEnv(*clientIDFlag, "AZ_CLIENT_ID")
+		if clientID == "" {
+			message += fmt.Sprintln("AZ_CLIENT_ID missing")
+		}
+		clientSecret = readClientSecret(&message)
+		if clientSecret == "" && getenv("AZ_CLIENT_SECRET_FILE") == "" {
+			message += fmt.Sprintln("AZ_CLIENT_SECRET or AZ_CLIENT_SECRET_FILE missing")
+		}
+	case "cert":
+		tenantID = flagOrEnv(*tenantIDFlag, "AZ_TENANT_ID")
+		if tenantID == "" {
+			message += fmt.Sprintln("AZ_TENANT_ID missing")
+		}
+		clientID = flagOrEnv(*clientIDFlag, "AZ_CLIENT_ID")
+		if clientID == "" {
+			message += fmt.Sprintln("AZ_CLIENT_ID missing")
+		}
+		clientCertPath = getenv("AZ_CLIENT_CERT_PATH")
+		if clientCertPath == "" {
+			message += fmt.Sprintln("AZ_CLIENT_CERT_PATH missing")
+		}
+		clientCertPassword = getenv("AZ_CLIENT_CERT_PASSWORD")
+	case "federated":
+		// Azure AD Workload Identity injects AZURE_TENANT_ID and AZURE_CLIENT_ID along with the token file.
+		tenantID = flagOrEnv(*tenantIDFlag, "AZ_TENANT_ID")
+		if tenantID == "" {
+			tenantID = getenv("AZURE_TENANT_ID")
+		}
+		if tenantID == "" {
+			message += fmt.Sprintln("AZ_TENANT_ID missing")
+		}
+		clientID = flagOrEnv(*clientIDFlag, "AZ_CLIENT_ID")
+		if clientID == "" {
+			clientID = getenv("AZURE_CLIENT_ID")
+		}
+		if clientID == "" {
+			message += fmt.Sprintln("AZ_CLIENT_ID missing")
+		}
+		federatedTokenFile = getenv("AZURE_FEDERATED_TOKEN_FILE")
+		if federatedTokenFile == "" {
+			message += fmt.Sprintln("AZURE_FEDERATED_TOKEN_FILE missing")
+		}
+	case "devicecode":
+		// Both are optional: by default the user signs in to their home tenant through the Azure CLI's app.
+		tenantID = flagOrEnv(*tenantIDFlag, "AZ_TENANT_ID")
+		clientID = flagOrEnv(*clientIDFlag, "AZ_CLIENT_ID")
+	default:
+		message += fmt.Sprintf("AUTH_METHOD %q is not one of secret, cert, federated, devicecode, msi, cli\n", authMethod)
 	}
-	userSecretVersion = os.Getenv("USER_SECRET_VERSION")
-	if userSecretVersion == "" {
-		message += fmt.Sprintln("USER_SECRET_VERSION missing")
+	disableTokenCache = *noCacheFlag
+	if value := getenv("DISABLE_TOKEN_CACHE"); value != "" && !disableTokenCache {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			message += fmt.Sprintf("DISABLE_TOKEN_CACHE %q is not true or false\n", value)
+		}
+		disableTokenCache = b
 	}
-	passwordSecretName = os.Getenv("PASSWORD_SECRET_NAME")
-	if userSecretName == "" {
-		message += fmt.Sprintln("PASSWORD_SECRET_NAME missing")
+	outputFileMode = 0600
+	if value := getenv("OUTPUT_FILE_MODE"); value != "" {
+		mode, err := strconv.ParseUint(value, 8, 32)
+		switch {
+		case err != nil || mode > 0777:
+			message += fmt.Sprintf("OUTPUT_FILE_MODE %q is not an octal file mode such as 0640\n", value)
+		case mode&0007 != 0 && !*insecurePermsFlag:
+			message += fmt.Sprintf("OUTPUT_FILE_MODE %s would let any user on the machine read or write the secrets; pass -allow-insecure-perms if that is intended\n", value)
+		}
+		outputFileMode = os.FileMode(mode)
 	}
-	passwordSecretVersion = os.Getenv("PASSWORD_SECRET_VERSION")
-	if passwordSecretVersion == "" {
-		message += fmt.Sprintln("PASSWORD_SECRET_VERSION missing")
+	quiet = *quietFlag
+	if value := getenv("QUIET"); value != "" && !quiet {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			message += fmt.Sprintf("QUIET %q is not true or false\n", value)
+		}
+		quiet = b
 	}
-	tenantID = os.Getenv("AZ_TENANT_ID")
-	if tenantID == "" {
-		message += fmt.Sprintln("AZ_TENANT_ID missing")
+	tokenCacheDir = getenv("TOKEN_CACHE_DIR")
+	tokenCacheKey = getenv("TOKEN_CACHE_KEY")
+	if value := getenv("TOKEN_REFRESH_SKEW"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			message += fmt.Sprintf("TOKEN_REFRESH_SKEW %q is not a duration such as 5m\n", value)
+		}
+		tokenRefreshSkew = d
 	}
-	clientID = os.Getenv("AZ_CLIENT_ID")
-	if clientID == "" {
-		message += fmt.Sprintln("AZ_CLIENT_ID missing")
+	environment = azure.PublicCloud
+	if name := getenv("AZURE_ENVIRONMENT"); name != "" {
+		env, err := azure.EnvironmentFromName(name)
+		if err != nil {
+			message += fmt.Sprintf("AZURE_ENVIRONMENT %q is not one of AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud, AzureGermanCloud\n", name)
+		}
+		environment = env
 	}
-	clientSecret = os.Getenv("AZ_CLIENT_SECRET")
-	if clientSecret == "" {
-		message += fmt.Sprintln("AZ_CLIENT_SECRET missing")
+	if value := getenv("VAULT_RESOURCE"); value != "" {
+		u, err := parseEndpointURL("VAULT_RESOURCE", value)
+		if err != nil {
+			message += fmt.Sprintln(err)
+		} else {
+			// Vaults are named <vault>.<host of the resource>, as vault.azure.net is for https://vault.azure.net.
+			environment.KeyVaultEndpoint = value
+			environment.KeyVaultDNSSuffix = u.Hostname()
+		}
+	}
+	transport := keyvaultclient.TransportOptions{CABundlePath: getenv("CA_BUNDLE")}
+	for _, t := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"DIAL_TIMEOUT", &transport.DialTimeout},
+		{"TLS_HANDSHAKE_TIMEOUT", &transport.TLSHandshakeTimeout},
+		{"IDLE_CONN_TIMEOUT", &transport.IdleConnTimeout},
+	} {
+		if value := getenv(t.name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				message += fmt.Sprintf("%s %q is not a positive duration such as 10s\n", t.name, value)
+			}
+			*t.d = d
+		}
+	}
+	// Only the exact value true turns verification off, and only for a vault outside the Azure clouds,
+	// which needs VAULT_RESOURCE anyway (a local emulator, say), so a stray setting can't expose real credentials.
+	if value := getenv("INSECURE_SKIP_VERIFY"); value != "" && value != "false" {
+		switch {
+		case value != "true":
+			message += fmt.Sprintf("INSECURE_SKIP_VERIFY %q is not true or false\n", value)
+		case getenv("VAULT_RESOURCE") == "":
+			message += "INSECURE_SKIP_VERIFY is only allowed with VAULT_RESOURCE set, for a local emulator; never against Azure\n"
+		case getenv("CA_BUNDLE") != "":
+			message += "INSECURE_SKIP_VERIFY can't be combined with CA_BUNDLE\n"
+		default:
+			// The per-request warnings must not be hidden by the default LOG_LEVEL.
+			if log.GetLevel() < log.WarnLevel {
+				log.SetLevel(log.WarnLevel)
+			}
+			log.Warn("INSECURE_SKIP_VERIFY is set: TLS certificates are NOT verified. Use this only for testing against a local emulator.")
+			transport.InsecureSkipVerify = true
+		}
+	}
+	if client, err := keyvaultclient.NewHTTPClientWithOptions(transport); err != nil {
+		message += fmt.Sprintf("CA_BUNDLE %q: %v\n", transport.CABundlePath, err)
+	} else {
+		httpClient = client
+	}
+	tokenEndpoint = getenv("AD_TOKEN_ENDPOINT")
+	if tokenEndpoint != "" {
+		if _, err := parseEndpointURL("AD_TOKEN_ENDPOINT", tokenEndpoint); err != nil {
+			message += fmt.Sprintln(err)
+		}
 	}
+	if vaultBaseURL != "" {
+		if err := validateVaultURL("VAULT_BASE_URL", vaultBaseURL, environment); err != nil {
+			message += fmt.Sprintln(err)
+		}
+	}
+	if value := getenv("MAX_CONCURRENCY"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			message += fmt.Sprintf("MAX_CONCURRENCY %q is not a positive integer\n", value)
+		}
+		maxConcurrency = n
+	}
+	if value := getenv("MAX_RETRIES"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			message += fmt.Sprintf("MAX_RETRIES %q is not a non-negative integer\n", value)
+		}
+		if n == 0 {
+			// keyvaultclient treats zero as "use the default"
+			n = -1
+		}
+		maxRetries = n
+	}
+	if value := getenv("REQUEST_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			message += fmt.Sprintf("REQUEST_TIMEOUT %q is not a duration such as 30s\n", value)
+		}
+		requestTimeout = d
+	}
+	if value := getenv("OPERATION_DEADLINE"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			message += fmt.Sprintf("OPERATION_DEADLINE %q is not a duration such as 2m\n", value)
+		}
+		operationDeadline = d
+	}
+	if value := getenv("REQUESTS_PER_SECOND"); value != "" {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || n <= 0 {
+			message += fmt.Sprintf("REQUESTS_PER_SECOND %q is not a positive number\n", value)
+		}
+		requestsPerSecond = n
+	}
+	if value := getenv("CIRCUIT_BREAKER_THRESHOLD"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			message += fmt.Sprintf("CIRCUIT_BREAKER_THRESHOLD %q is not a non-negative integer\n", value)
+		}
+		breakerThreshold = n
+	}
+	if value := getenv("CIRCUIT_BREAKER_WINDOW"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			message += fmt.Sprintf("CIRCUIT_BREAKER_WINDOW %q is not a duration such as 1m\n", value)
+		}
+		breakerWindow = d
+	}
+	if value := getenv("CIRCUIT_BREAKER_COOLDOWN"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			message += fmt.Sprintf("CIRCUIT_BREAKER_COOLDOWN %q is not a duration such as 30s\n", value)
+		}
+		breakerCooldown = d
+	}
+	if value := getenv("SECRET_CACHE_TTL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			message += fmt.Sprintf("SECRET_CACHE_TTL %q is not a duration such as 5m\n", value)
+		}
+		secretCacheTTL = d
+	}
+	auditLogPath = getenv("AUDIT_LOG_PATH")
+	if value := getenv("STRICT_EXPIRY"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			message += fmt.Sprintf("STRICT_EXPIRY %q is not true or false\n", value)
+		}
+		strictExpiry = b
+	}
+	if value := getenv("STRICT_MODE"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			message += fmt.Sprintf("STRICT_MODE %q is not true or false\n", value)
+		}
+		strictMode = b
+	}
+
+	outputFormat = getenv("OUTPUT_FORMAT")
+	switch outputFormat {
+	case "":
+		outputFormat = "text"
+	case "text", "dotenv", "json":
+	default:
+		message += fmt.Sprintf("OUTPUT_FORMAT %q is not one of text, dotenv, json\n", outputFormat)
+	}
+
+	if *decodeFlag != "" && *decodeFlag != "base64" {
+		message += fmt.Sprintf("-decode %q is not base64\n", *decodeFlag)
+	}
+
+	pollInterval = defaultPollInterval
+	if value := getenv("POLL_INTERVAL"); value != "" {
+		d, err := parsePollInterval(value)
+		if err != nil {
+			message += fmt.Sprintln(err)
+		}
+		pollInterval = d
+	}
+	reloadCommand = getenv("RELOAD_COMMAND")
+
+	appConfigEndpoint = getenv("APP_CONFIG_ENDPOINT")
+	if appConfigEndpoint != "" {
+		if u, err := url.Parse(appConfigEndpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+			message += fmt.Sprintf("APP_CONFIG_ENDPOINT %q is not an https URL\n", appConfigEndpoint)
+		}
+	}
+	appConfigKeyFilter = getenv("APP_CONFIG_KEY_FILTER")
+	appConfigLabel = getenv("APP_CONFIG_LABEL")
+
+	defaultSecretVersion = getenv("DEFAULT_SECRET_VERSION")
+	if strings.ContainsAny(defaultSecretVersion, "/# ") {
+		message += fmt.Sprintf("DEFAULT_SECRET_VERSION %q is not a secret version\n", defaultSecretVersion)
+	}
+
+	if *secretFlag != "" {
+		explicit := *secretVersionFlag != "" || strings.Contains(*secretFlag, "#")
+		name, version, err := resolveSecretVersion("-secret", *secretFlag, *secretVersionFlag)
+		if err != nil {
+			message += fmt.Sprintln(err)
+		}
+		*secretFlag, *secretVersionFlag = name, versionOrDefault(version, explicit)
+	}
+
+	if len(message) > 0 {
+		message += "| need to be defined as flags, in .env or as environment variables."
+		return errors.New(message)
+	}
+	return nil
+}
+
+// readClientSecret returns the Service Principal's client secret: the contents of AZ_CLIENT_SECRET_FILE,
+// such as a Docker or Kubernetes secret mount, when it is set, and otherwise AZ_CLIENT_SECRET. Reading it
+// from a file keeps it out of the process environment. Trailing whitespace is trimmed.
+func readClientSecret(message *string) string {
+	path := getenv("AZ_CLIENT_SECRET_FILE")
+	if path == "" {
+		return getenv("AZ_CLIENT_SECRET")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		*message += fmt.Sprintf("AZ_CLIENT_SECRET_FILE could not be read: %v\n", err)
+		return ""
+	}
+	secret := strings.TrimRight(string(data), " \t\r\n")
+	if secret == "" {
+		*message += fmt.Sprintf("AZ_CLIENT_SECRET_FILE %q is empty\n", path)
+	}
+	return secret
+}
+
+// validateVaultURL checks that a vault URL looks like https://<vault>.vault.azure.net, with the Key Vault
+// DNS suffix of the configured cloud, or like the https://<pool>.managedhsm.azure.net of a Managed HSM
+// pool, so a typo fails here rather than deep inside the SDK. The errors name the setting the URL came from.
+func validateVaultURL(setting string, vaultURL string, env azure.Environment) error {
+	u, err := url.Parse(vaultURL)
+	if err != nil {
+		return fmt.Errorf("%s %q is not a valid URL: %v", setting, vaultURL, err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%s %q must start with https://", setting, vaultURL)
+	}
+	vaultName := strings.TrimSuffix(u.Hostname(), "."+env.KeyVaultDNSSuffix)
+	if hsm := keyvaultclient.ManagedHSMDNSSuffix(env); hsm != "" && strings.HasSuffix(u.Hostname(), "."+hsm) {
+		vaultName = strings.TrimSuffix(u.Hostname(), "."+hsm)
+	}
+	if vaultName == u.Hostname() || vaultName == "" || strings.Contains(vaultName, ".") {
+		return fmt.Errorf("%s %q is not of the form https://<vault>.%s for %s", setting, vaultURL, env.KeyVaultDNSSuffix, env.Name)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%s %q must not have a path or query", setting, vaultURL)
+	}
+	return nil
+}
+
+// parseEndpointURL checks that an endpoint override is an absolute https URL.
+func parseEndpointURL(setting string, value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q is not a valid URL: %v", setting, value, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%s %q must be an https:// URL", setting, value)
+	}
+	return u, nil
+}
+
+// envPrefix is ENV_PREFIX with a trailing _, under which every setting is looked for first.
+var envPrefix string
+
+// setEnvPrefix reads ENV_PREFIX. APP1 and APP1_ both have AZ_CLIENT_ID read from APP1_AZ_CLIENT_ID.
+func setEnvPrefix() {
+	envPrefix = os.Getenv("ENV_PREFIX")
+	if envPrefix != "" && !strings.HasSuffix(envPrefix, "_") {
+		envPrefix += "_"
+	}
+}
+
+// lookupEnv is os.LookupEnv for a setting: the ENV_PREFIX variant of name when that is set, even if
+// empty, and name itself otherwise.
+func lookupEnv(name string) (string, bool) {
+	if envPrefix != "" {
+		if value, ok := os.LookupEnv(envPrefix + name); ok {
+			return value, true
+		}
+	}
+	return os.LookupEnv(name)
+}
+
+// getenv is os.Getenv for a setting, looked up as lookupEnv does.
+func getenv(name string) string {
+	value, _ := lookupEnv(name)
+	return value
+}
+
+// flagOrEnv returns the flag value when it was given, falling back to the named environment variable.
+func flagOrEnv(flagValue string, envName string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return getenv(envName)
+}
+
+// parseSecretArgs reads the names and versions of the secrets fetched by the default command.
+func parseSecretArgs() error {
+	var message string
+	userSecretName, userSecretVersion = secretNameAndVersion("USER_SECRET_NAME", "USER_SECRET_VERSION", &message)
+	passwordSecretName, passwordSecretVersion = secretNameAndVersion("PASSWORD_SECRET_NAME", "PASSWORD_SECRET_VERSION", &message)
+	userVaultURL = secretVaultURL("USER_SECRET_VAULT_URL", &message)
+	passwordVaultURL = secretVaultURL("PASSWORD_SECRET_VAULT_URL", &message)
 
 	if len(message) > 0 {
 		message += "| need to be defined in .env or environment variable."
@@ -224,8 +1505,94 @@ func parseArgs() error {
 	return nil
 }
 
+// secretNameAndVersion reads a secret's name, which may be given as name#version, and version from the
+// environment. A version is required one way or the other, unless DEFAULT_SECRET_VERSION is set; name#
+// asks for the current one.
+func secretNameAndVersion(nameEnv string, versionEnv string, message *string) (string, string) {
+	value := getenv(nameEnv)
+	if value == "" {
+		*message += fmt.Sprintf("%s missing\n", nameEnv)
+		return "", ""
+	}
+	explicit := getenv(versionEnv) != "" || strings.Contains(value, "#")
+	name, version, err := resolveSecretVersion(nameEnv, value, getenv(versionEnv))
+	if err != nil {
+		*message += fmt.Sprintln(err)
+	} else if !explicit && defaultSecretVersion == "" {
+		*message += fmt.Sprintf("%s missing\n", versionEnv)
+	}
+	return name, versionOrDefault(version, explicit)
+}
+
+// versionOrDefault returns version, or DEFAULT_SECRET_VERSION when no version was given for the secret
+// at all. explicit says one was, even if only as a trailing # asking for the current version.
+func versionOrDefault(version string, explicit bool) string {
+	if explicit {
+		return version
+	}
+	return defaultSecretVersion
+}
+
+// resolveSecretVersion splits a secret given as name#version, the form every setting naming a secret
+// accepts, into its name and version. An empty version after the # means the current version. version is
+// the one given separately, if any, which must agree with an inline version.
+func resolveSecretVersion(setting string, value string, version string) (string, string, error) {
+	i := strings.LastIndex(value, "#")
+	if i < 0 {
+		return value, version, nil
+	}
+	name, inline := value[:i], value[i+1:]
+	if name == "" {
+		return "", "", fmt.Errorf("%s %q has no secret name before the #", setting, value)
+	}
+	if version != "" && version != inline {
+		return "", "", fmt.Errorf("%s %q conflicts with its separately given version %s", setting, value, version)
+	}
+	return name, inline, nil
+}
+
+// secretVaultURL returns the vault a secret is read from: the one in envName if set, otherwise VAULT_BASE_URL.
+// An invalid URL is added to message.
+func secretVaultURL(envName string, message *string) string {
+	value := getenv(envName)
+	if value == "" {
+		return vaultBaseURL
+	}
+	if err := validateVaultURL(envName, value, environment); err != nil {
+		*message += fmt.Sprintln(err)
+	}
+	return value
+}
+
+// setLogFormat logs JSON lines, for log collectors, unless LOG_FORMAT=text asks for lines meant to be
+// read, colored when they go to a terminal.
+func setLogFormat() {
+	switch format := getenv("LOG_FORMAT"); format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	case "", "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.SetFormatter(&log.JSONFormatter{})
+		log.Warnf("LOG_FORMAT %q is not one of json, text; logging JSON", format)
+	}
+}
+
+// setLogOutput sends the logs to stderr, keeping stdout for secret output, unless LOG_OUTPUT=stdout.
+func setLogOutput() {
+	switch output := getenv("LOG_OUTPUT"); output {
+	case "stdout":
+		log.SetOutput(os.Stdout)
+	case "", "stderr":
+		log.SetOutput(os.Stderr)
+	default:
+		log.SetOutput(os.Stderr)
+		log.Warnf("LOG_OUTPUT %q is not one of stderr, stdout; logging to stderr", output)
+	}
+}
+
 func setLogLevel() {
-	level := os.Getenv("LOG_LEVEL")
+	level := getenv("LOG_LEVEL")
 	switch level {
 	case "INFO":
 		log.SetLevel(log.InfoLevel)
@@ -237,13 +1604,3 @@ func setLogLevel() {
 		log.SetLevel(log.ErrorLevel)
 	}
 }
-
-func timeTrack(start time.Time, name string) {
-	elapsed := time.Since(start)
-	log.WithFields(log.Fields{
-		"function":    name,
-		"elapsed(ns)": elapsed.Nanoseconds(),
-		"elapsed":     elapsed.String(),
-	}).Info("Timings")
-
-}
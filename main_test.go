@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// secretEnv are the settings parseSecretArgs reads.
+var secretEnv = []string{
+	"USER_SECRET_NAME", "USER_SECRET_VERSION", "PASSWORD_SECRET_NAME", "PASSWORD_SECRET_VERSION",
+	"USER_SECRET_VAULT_URL", "PASSWORD_SECRET_VAULT_URL",
+}
+
+// setSecretEnv sets env as the only secret settings for the rest of the test.
+func setSecretEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, name := range secretEnv {
+		t.Setenv(name, "")
+		if value, ok := env[name]; ok {
+			os.Setenv(name, value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestParseSecretArgsReportsEachMissingVariableOnce(t *testing.T) {
+	envPrefix, defaultSecretVersion = "", ""
+	for _, tt := range []struct {
+		name    string
+		env     map[string]string
+		missing []string
+	}{
+		{
+			name:    "nothing set",
+			missing: []string{"USER_SECRET_NAME", "PASSWORD_SECRET_NAME"},
+		},
+		{
+			name:    "password secret missing",
+			env:     map[string]string{"USER_SECRET_NAME": "UserName", "USER_SECRET_VERSION": "v1"},
+			missing: []string{"PASSWORD_SECRET_NAME"},
+		},
+		{
+			name:    "versions missing",
+			env:     map[string]string{"USER_SECRET_NAME": "UserName", "PASSWORD_SECRET_NAME": "Password"},
+			missing: []string{"USER_SECRET_VERSION", "PASSWORD_SECRET_VERSION"},
+		},
+		{
+			name:    "password version missing",
+			env:     map[string]string{"USER_SECRET_NAME": "UserName#", "PASSWORD_SECRET_NAME": "Password"},
+			missing: []string{"PASSWORD_SECRET_VERSION"},
+		},
+		{
+			name: "everything set",
+			env: map[string]string{
+				"USER_SECRET_NAME": "UserName", "USER_SECRET_VERSION": "v1",
+				"PASSWORD_SECRET_NAME": "Password#v2",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setSecretEnv(t, tt.env)
+			err := parseSecretArgs()
+			if len(tt.missing) == 0 {
+				if err != nil {
+					t.Fatalf("parseSecretArgs() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("parseSecretArgs() = nil, want %v reported missing", tt.missing)
+			}
+
+			lines := strings.Split(err.Error(), "\n")
+			for _, name := range secretEnv {
+				want := 0
+				for _, m := range tt.missing {
+					if m == name {
+						want = 1
+					}
+				}
+				got := 0
+				for _, line := range lines {
+					if strings.Contains(line, name) {
+						got++
+					}
+				}
+				if got != want {
+					t.Errorf("%s is in %d line(s) of the error, want %d:\n%v", name, got, want, err)
+				}
+			}
+		})
+	}
+}
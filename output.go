@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// outputTemplate is the parsed -template file. When set it replaces OUTPUT_FORMAT.
+var outputTemplate *template.Template
+
+// base64Secrets are the names of the secrets the config file says to base64-decode before they are
+// written. -decode base64 decodes every secret.
+var base64Secrets map[string]bool
+
+// decodeSecrets returns secrets with the values -decode or the config file ask for decoded, and then with
+// the field their jsonpath names extracted. A value that isn't valid base64, or JSON without that field,
+// is an error, rather than being written as it is.
+func decodeSecrets(secrets []keyvaultclient.Secret) ([]keyvaultclient.Secret, error) {
+	decoded := make([]keyvaultclient.Secret, len(secrets))
+	for i, s := range secrets {
+		decoded[i] = s
+		if *decodeFlag == "base64" || base64Secrets[s.Name] {
+			value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(s.Value)))
+			if err != nil {
+				return nil, fmt.Errorf("secret %s is not valid base64: %v", s.Name, err)
+			}
+			decoded[i].Value = keyvaultclient.RedactedString(value)
+		}
+		if path, ok := jsonPaths[s.Name]; ok {
+			value, err := path.extract(string(decoded[i].Value))
+			if err != nil {
+				return nil, fmt.Errorf("secret %s %v (jsonpath %s)", s.Name, err, path.expr)
+			}
+			decoded[i].Value = keyvaultclient.RedactedString(value)
+		}
+	}
+	return decoded, nil
+}
+
+// outputSinks are the outputs declared in the config file. Without any, the secrets are written once,
+// as defaultSink describes.
+var outputSinks []outputSink
+
+// outputSink is one rendering of the fetched secrets: in Format (text, dotenv or json), or through
+// Template when set, written to Path, or to stdout when Path is empty or "-".
+type outputSink struct {
+	Format   string
+	Template *template.Template
+	Path     string
+}
+
+// defaultSink writes in OUTPUT_FORMAT, or through -template, to -out.
+func defaultSink() outputSink {
+	return outputSink{Format: outputFormat, Template: outputTemplate, Path: *outFlag}
+}
+
+// sinks returns the sinks a run writes to: outputSinks, or the default sink when there are none.
+func sinks() []outputSink {
+	if len(outputSinks) > 0 {
+		return outputSinks
+	}
+	return []outputSink{defaultSink()}
+}
+
+// customOutput reports whether the output was configured in any way, rather than the plain text the
+// tool prints by default.
+func customOutput() bool {
+	return len(outputSinks) > 0 || outputFormat != "text" || outputTemplate != nil || *outFlag != "" || *decodeFlag != ""
+}
+
+func (s outputSink) String() string {
+	if s.Path == "" || s.Path == "-" {
+		return "stdout"
+	}
+	return s.Path
+}
+
+// render writes secrets to w, where text is one name=value line per secret. envVars optionally gives
+// the variable dotenv output uses for a secret name, in place of the name derived by dotenvName.
+func (s outputSink) render(w io.Writer, secrets []keyvaultclient.Secret, envVars map[string]string) error {
+	switch {
+	case s.Template != nil:
+		return writeTemplate(w, s.Template, secrets)
+	case s.Format == "json":
+		return writeJSON(w, secrets)
+	case s.Format == "dotenv":
+		return writeDotenv(w, secrets, envVars)
+	}
+	for _, secret := range secrets {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", secret.Name, string(secret.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write renders secrets to the sink's file, or to stdout.
+func (s outputSink) write(secrets []keyvaultclient.Secret, envVars map[string]string) error {
+	if s.Path == "" || s.Path == "-" {
+		return s.render(os.Stdout, secrets, envVars)
+	}
+	var buf bytes.Buffer
+	if err := s.render(&buf, secrets, envVars); err != nil {
+		return err
+	}
+	return writeSecretFile(s.Path, buf.Bytes())
+}
+
+// writeSecretFile replaces the file at path with data, with the permissions OUTPUT_FILE_MODE gives (0600
+// by default). The data is written to a temporary file in the same directory that is renamed over path,
+// so readers see either the old file or the new one, never a partial write.
+func writeSecretFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(outputFileMode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeOutputs writes secrets to every sink. A sink that can't be written is logged and skipped so the
+// others are still written; the error then names each one that failed.
+func writeOutputs(secrets []keyvaultclient.Secret, envVars map[string]string) error {
+	secrets, err := decodeSecrets(secrets)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, s := range sinks() {
+		if err := s.write(secrets, envVars); err != nil {
+			log.Warnf("Could not write secrets to %s: %v", s, err)
+			failed = append(failed, s.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not write to %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// templateFuncs are the functions -template files can use besides the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"quote": strconv.Quote,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// loadTemplate parses a -template file. Referring to a secret that wasn't fetched is an error rather
+// than an empty string.
+func loadTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(templateFuncs).Option("missingkey=error").ParseFiles(path)
+}
+
+// templateData is what -template files are executed with: .Secrets maps each secret's name to its value.
+type templateData struct {
+	Secrets map[string]string
+}
+
+func writeTemplate(w io.Writer, tmpl *template.Template, secrets []keyvaultclient.Secret) error {
+	data := templateData{Secrets: make(map[string]string, len(secrets))}
+	for _, s := range secrets {
+		data.Secrets[s.Name] = string(s.Value)
+	}
+	return tmpl.Execute(w, data)
+}
+
+// jsonSecret is how a secret is rendered by OUTPUT_FORMAT=json.
+type jsonSecret struct {
+	Value       string    `json:"value"`
+	Version     string    `json:"version"`
+	ContentType string    `json:"contentType"`
+	Updated     time.Time `json:"updated"`
+}
+
+// writeJSON writes secrets as a single JSON object keyed by secret name.
+func writeJSON(w io.Writer, secrets []keyvaultclient.Secret) error {
+	out := make(map[string]jsonSecret, len(secrets))
+	for _, s := range secrets {
+		out[s.Name] = jsonSecret{Value: string(s.Value), Version: s.Version, ContentType: s.ContentType, Updated: s.Updated}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeSecretInfo writes a secret's metadata as an aligned table, one property per line.
+func writeSecretInfo(w io.Writer, info keyvaultclient.SecretInfo) error {
+	tags := make([]string, 0, len(info.Tags))
+	for k, v := range info.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range [][2]string{
+		{"Name", info.Name},
+		{"Version", info.Version},
+		{"ID", info.ID},
+		{"Content type", info.ContentType},
+		{"Enabled", strconv.FormatBool(info.Enabled)},
+		{"Created", formatInfoTime(info.Created)},
+		{"Updated", formatInfoTime(info.Updated)},
+		{"Not before", formatInfoTime(info.NotBefore)},
+		{"Expires", formatInfoTime(info.Expires)},
+		{"Tags", strings.Join(tags, ", ")},
+		{"Recovery level", info.RecoveryLevel},
+		{"Managed", strconv.FormatBool(info.Managed)},
+	} {
+		fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+	}
+	return tw.Flush()
+}
+
+func formatInfoTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// jsonSecretInfo is how secret info is rendered by OUTPUT_FORMAT=json. Unset dates are left out.
+type jsonSecretInfo struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Version       string            `json:"version"`
+	ContentType   string            `json:"contentType"`
+	Tags          map[string]string `json:"tags"`
+	Enabled       bool              `json:"enabled"`
+	Created       *time.Time        `json:"created,omitempty"`
+	Updated       *time.Time        `json:"updated,omitempty"`
+	NotBefore     *time.Time        `json:"notBefore,omitempty"`
+	Expires       *time.Time        `json:"expires,omitempty"`
+	RecoveryLevel string            `json:"recoveryLevel"`
+	Managed       bool              `json:"managed"`
+}
+
+func writeSecretInfoJSON(w io.Writer, info keyvaultclient.SecretInfo) error {
+	optional := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonSecretInfo{
+		ID:            info.ID,
+		Name:          info.Name,
+		Version:       info.Version,
+		ContentType:   info.ContentType,
+		Tags:          info.Tags,
+		Enabled:       info.Enabled,
+		Created:       optional(info.Created),
+		Updated:       optional(info.Updated),
+		NotBefore:     optional(info.NotBefore),
+		Expires:       optional(info.Expires),
+		RecoveryLevel: info.RecoveryLevel,
+		Managed:       info.Managed,
+	})
+}
+
+// writeDeletedSecrets writes soft-deleted secrets as an aligned table, one per line.
+func writeDeletedSecrets(w io.Writer, deleted []keyvaultclient.DeletedSecretInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDELETED\tPURGE DATE")
+	for _, d := range deleted {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Name, formatInfoTime(d.DeletedDate), formatInfoTime(d.ScheduledPurgeDate))
+	}
+	return tw.Flush()
+}
+
+// jsonDeletedSecret is how a soft-deleted secret is rendered by OUTPUT_FORMAT=json.
+type jsonDeletedSecret struct {
+	Name               string     `json:"name"`
+	DeletedDate        *time.Time `json:"deletedDate,omitempty"`
+	ScheduledPurgeDate *time.Time `json:"scheduledPurgeDate,omitempty"`
+}
+
+func writeDeletedSecretsJSON(w io.Writer, deleted []keyvaultclient.DeletedSecretInfo) error {
+	optional := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	out := make([]jsonDeletedSecret, len(deleted))
+	for i, d := range deleted {
+		out[i] = jsonDeletedSecret{Name: d.Name, DeletedDate: optional(d.DeletedDate), ScheduledPurgeDate: optional(d.ScheduledPurgeDate)}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeExpiringCertificates prints certificates as a table, with how many whole days each has left.
+func writeExpiringCertificates(w io.Writer, certs []keyvaultclient.CertificateInfo, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tEXPIRES\tDAYS LEFT")
+	for _, c := range certs {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", c.Name, formatInfoTime(c.Expires), daysLeft(c.Expires, now))
+	}
+	return tw.Flush()
+}
+
+// jsonCertificate is how an expiring certificate is rendered by OUTPUT_FORMAT=json.
+type jsonCertificate struct {
+	Name       string    `json:"name"`
+	Expires    time.Time `json:"expires"`
+	DaysLeft   int       `json:"daysLeft"`
+	Enabled    bool      `json:"enabled"`
+	Thumbprint string    `json:"thumbprint,omitempty"`
+}
+
+func writeExpiringCertificatesJSON(w io.Writer, certs []keyvaultclient.CertificateInfo, now time.Time) error {
+	out := make([]jsonCertificate, len(certs))
+	for i, c := range certs {
+		out[i] = jsonCertificate{Name: c.Name, Expires: c.Expires, DaysLeft: daysLeft(c.Expires, now), Enabled: c.Enabled, Thumbprint: c.Thumbprint}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// daysLeft is the number of whole days from now until t, negative once t has passed.
+func daysLeft(t time.Time, now time.Time) int {
+	return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+// dotenvValueReplacer escapes a value for a double-quoted .env entry. gotenv turns \n and \r back into
+// newlines and strips the backslash from everything else; \$ keeps a literal $ from being expanded.
+var dotenvValueReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`$`, `\$`,
+	"`", "\\`",
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// writeDotenv writes secrets as NAME="value" lines that can be redirected into a .env file.
+func writeDotenv(w io.Writer, secrets []keyvaultclient.Secret, envVars map[string]string) error {
+	for _, s := range secrets {
+		name, ok := envVars[s.Name]
+		if !ok {
+			name = dotenvName(s.Name)
+		}
+		_, err := fmt.Fprintf(w, "%s=\"%s\"\n", name, dotenvValueReplacer.Replace(string(s.Value)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envVarPattern matches the names checkEnvVars accepts for targetEnvVar.
+var envVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writesDotenv reports whether the secrets are written as dotenv, the only format that renames them.
+func writesDotenv() bool {
+	for _, s := range sinks() {
+		if s.Format == "dotenv" && s.Template == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEnvVars makes sure dotenv output gives each secret in refs its own variable, so that one value
+// can't silently replace another: every name given in envVars must be valid, and no two secrets may end
+// up with the same name, whether given or derived by dotenvName.
+func checkEnvVars(refs []keyvaultclient.SecretRef, envVars map[string]string) error {
+	owners := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		name, ok := envVars[ref.Name]
+		if !ok {
+			name = dotenvName(ref.Name)
+		} else if !envVarPattern.MatchString(name) {
+			return fmt.Errorf("targetEnvVar %q of %s is not a valid variable name", name, ref.Name)
+		}
+		if owner, ok := owners[name]; ok {
+			return fmt.Errorf("%s and %s would both be written as %s", owner, ref.Name, name)
+		}
+		owners[name] = ref.Name
+	}
+	return nil
+}
+
+// dotenvName maps a secret name onto an environment variable name: letters are upper-cased and
+// anything other than a letter, digit or underscore becomes an underscore, so db-password becomes
+// DB_PASSWORD. A name starting with a digit is prefixed with an underscore.
+func dotenvName(name string) string {
+	var b strings.Builder
+	for i, r := range strings.ToUpper(name) {
+		switch {
+		case r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// secretsFileEntry is an entry of a JSON secrets file given as an object rather than a bare name.
+type secretsFileEntry struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	VaultBaseURL string `json:"vaultBaseURL"`
+}
+
+// readSecretRefs reads the secrets listed in a secrets file. The file is either one name (or
+// name#version) per line, where blank lines and lines starting with # are ignored, or a JSON array whose
+// entries are names or {"name", "version", "vaultBaseURL"} objects. Entries without a vault are read from
+// defaultVaultURL.
+func readSecretRefs(path string, defaultVaultURL string) ([]keyvaultclient.SecretRef, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("%s is not a JSON array: %v", path, err)
+		}
+		refs := make([]keyvaultclient.SecretRef, len(entries))
+		for i, raw := range entries {
+			var entry secretsFileEntry
+			if err := json.Unmarshal(raw, &entry.Name); err != nil {
+				if err := json.Unmarshal(raw, &entry); err != nil {
+					return nil, fmt.Errorf("%s: entry %d is neither a secret name nor an object: %v", path, i, err)
+				}
+			}
+			if entry.Name == "" {
+				return nil, fmt.Errorf("%s: entry %d has no name", path, i)
+			}
+			explicit := entry.Version != "" || strings.Contains(entry.Name, "#")
+			entry.Name, entry.Version, err = resolveSecretVersion("name", entry.Name, entry.Version)
+			if err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %v", path, i, err)
+			}
+			entry.Version = versionOrDefault(entry.Version, explicit)
+			if entry.VaultBaseURL == "" {
+				entry.VaultBaseURL = defaultVaultURL
+			}
+			refs[i] = keyvaultclient.SecretRef{VaultBaseURL: entry.VaultBaseURL, Name: entry.Name, Version: entry.Version}
+		}
+		return refs, nil
+	}
+
+	var refs []keyvaultclient.SecretRef
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, version, err := resolveSecretVersion("secret", line, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		refs = append(refs, keyvaultclient.SecretRef{VaultBaseURL: defaultVaultURL, Name: name, Version: versionOrDefault(version, strings.Contains(line, "#"))})
+	}
+	return refs, scanner.Err()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// The makefile does this for you.
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// printVersion writes the build information -version reports.
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "goAzureKeyVault %s (commit %s, built %s, %s %s/%s)\n", version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
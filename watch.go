@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stevebargelt/goAzureKeyVault/keyvaultclient"
+)
+
+// defaultPollInterval is how often -watch checks for new secret versions when POLL_INTERVAL is unset.
+const defaultPollInterval = 60 * time.Second
+
+// maxPollBackoff caps how far -watch widens the poll interval while Azure can't be reached.
+const maxPollBackoff = 15 * time.Minute
+
+// watchedSecrets returns the secrets -watch keeps in sync: the one named by -secret, or those listed in
+// -secrets-file, the -config file or App Configuration. The list is read once, at start up.
+func watchedSecrets(cfg fileConfig) ([]keyvaultclient.SecretRef, map[string]string) {
+	switch {
+	case *secretFlag != "":
+		return []keyvaultclient.SecretRef{{VaultBaseURL: vaultBaseURL, Name: *secretFlag, Version: *secretVersionFlag}}, nil
+	case *secretsFileFlag != "":
+		refs, err := readSecretRefs(*secretsFileFlag, vaultBaseURL)
+		if err != nil {
+			fatalf(exitConfig, "Could not read secrets file: %v", err)
+		}
+		return refs, nil
+	case len(cfg.Secrets) > 0:
+		return cfg.secretRefs(vaultBaseURL)
+	case appConfigEndpoint != "":
+		return appConfigSecrets()
+	}
+	fatalf(exitConfig, "-watch needs the secrets to watch from -secret, -secrets-file, -config or APP_CONFIG_ENDPOINT")
+	return nil, nil
+}
+
+// runWatch polls refs every pollInterval and rewrites path in OUTPUT_FORMAT whenever one of them has a
+// new version, then runs RELOAD_COMMAND if set. While Azure can't be reached the interval doubles after
+// each failed poll, up to maxPollBackoff, and goes back to pollInterval once a poll gets through. It returns once SIGINT or SIGTERM is received: a poll in
+// progress is cut short, as its requests are cancelled, but the secrets it already fetched are written.
+func runWatch(refs []keyvaultclient.SecretRef, envVars map[string]string, path string) {
+	if path == "" {
+		fatalf(exitConfig, "-watch needs -out to name the file the secrets are written to")
+	}
+	for _, ref := range refs {
+		if err := validateVaultURL("vaultBaseURL of "+ref.Name, ref.VaultBaseURL, environment); err != nil {
+			fatalf(exitConfig, "Invalid vault: %v", err)
+		}
+	}
+	if writesDotenv() {
+		if err := checkEnvVars(refs, envVars); err != nil {
+			fatalf(exitConfig, "Invalid variable names: %v", err)
+		}
+	}
+
+	client := newClient()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	current := map[keyvaultclient.SecretRef]keyvaultclient.Secret{}
+
+	delay := pollInterval
+	for {
+		changed, err := pollSecrets(ctx, client, refs, current)
+		switch {
+		case err != nil && unreachable(err):
+			delay = nextPollDelay(delay)
+			if ctx.Err() == nil {
+				log.Warnf("Could not poll secrets, polling again in %s: %v", delay, err)
+			}
+		case err != nil:
+			delay = pollInterval
+			if ctx.Err() == nil {
+				log.Warnf("Could not poll secrets: %v", err)
+			}
+		default:
+			delay = pollInterval
+		}
+		switch {
+		case changed && strictMode && !allRead(refs, current):
+			// The file is written once the missing secrets are read, as that changes current.
+			log.Warnf("STRICT_MODE is set, so %s isn't written until every secret has been read", path)
+		case changed:
+			if err := writeWatchedSecrets(path, refs, current, envVars); err != nil {
+				log.Errorf("Could not write secrets to %s: %v", path, err)
+			} else {
+				log.Infof("Secrets changed, rewrote %s", path)
+				runReloadCommand()
+			}
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			log.Infof("Shutting down, stopped watching %d secret(s)", len(refs))
+			return
+		}
+	}
+}
+
+// allRead reports whether current holds every one of refs.
+func allRead(refs []keyvaultclient.SecretRef, current map[keyvaultclient.SecretRef]keyvaultclient.Secret) bool {
+	for _, ref := range refs {
+		if _, ok := current[ref]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// nextPollDelay doubles delay, up to maxPollBackoff or pollInterval when that is longer.
+func nextPollDelay(delay time.Duration) time.Duration {
+	limit := maxPollBackoff
+	if pollInterval > limit {
+		limit = pollInterval
+	}
+	if delay *= 2; delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// unreachable reports whether every secret a poll failed on failed because Azure couldn't be reached, as
+// opposed to a secret having been deleted or access to it denied. A token that couldn't be refreshed,
+// which is how an Azure AD outage shows, counts as unreachable too.
+func unreachable(err error) bool {
+	failed, ok := err.(keyvaultclient.SecretsError)
+	if !ok {
+		failed = keyvaultclient.SecretsError{"": err}
+	}
+	for _, err := range failed {
+		if exitCode(err) != exitNetwork && !errors.Is(err, keyvaultclient.ErrAuthentication) {
+			return false
+		}
+	}
+	return true
+}
+
+// pollSecrets brings current up to date with refs, reporting whether any secret changed. A secret's value
+// is only fetched when its current version id differs from the one already held, and secrets pinned to a
+// version are fetched once.
+func pollSecrets(ctx context.Context, client *keyvaultclient.Client, refs []keyvaultclient.SecretRef, current map[keyvaultclient.SecretRef]keyvaultclient.Secret) (bool, error) {
+	changed := false
+	failed := keyvaultclient.SecretsError{}
+	for _, ref := range refs {
+		held, ok := current[ref]
+		if ok && ref.Version != "" {
+			continue
+		}
+
+		version := ref.Version
+		if version == "" {
+			versions, err := client.ListSecretVersions(ctx, ref.VaultBaseURL, ref.Name)
+			if err != nil {
+				failed[ref.String()] = err
+				continue
+			}
+			if len(versions) == 0 {
+				failed[ref.String()] = keyvaultclient.ErrSecretNotFound
+				continue
+			}
+			// Key Vault serves the most recently created version as the current one.
+			version = versions[0].Version
+			if ok && held.Version == version {
+				continue
+			}
+		}
+
+		secret, err := client.GetSecretDetails(ctx, ref.VaultBaseURL, ref.Name, version)
+		if err != nil {
+			failed[ref.String()] = err
+			continue
+		}
+		log.Infof("Fetched new version of secret %s. version=%s", ref.Name, secret.Version)
+		current[ref] = secret
+		changed = true
+	}
+	if len(failed) > 0 {
+		return changed, failed
+	}
+	return changed, nil
+}
+
+// writeWatchedSecrets writes the secrets held for refs, in refs order, to path.
+func writeWatchedSecrets(path string, refs []keyvaultclient.SecretRef, current map[keyvaultclient.SecretRef]keyvaultclient.Secret, envVars map[string]string) error {
+	var secrets []keyvaultclient.Secret
+	for _, ref := range refs {
+		if secret, ok := current[ref]; ok {
+			secrets = append(secrets, secret)
+		}
+	}
+	secrets, err := decodeSecrets(secrets)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := defaultSink().render(&buf, secrets, envVars); err != nil {
+		return err
+	}
+	return writeSecretFile(path, buf.Bytes())
+}
+
+// runReloadCommand runs RELOAD_COMMAND, if set, so the app using the secrets picks up the new values.
+// The command is split on whitespace and run directly, not through a shell.
+func runReloadCommand() {
+	args := strings.Fields(reloadCommand)
+	if len(args) == 0 {
+		return
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Errorf("Reload command %q failed: %v", reloadCommand, err)
+		return
+	}
+	log.Infof("Ran reload command %q", reloadCommand)
+}
+
+// parsePollInterval accepts POLL_INTERVAL either as a number of seconds or as a duration such as 5m.
+func parsePollInterval(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if seconds, atoiErr := strconv.Atoi(value); atoiErr == nil {
+		d, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("POLL_INTERVAL %q is not a number of seconds or a duration such as 5m", value)
+	}
+	return d, nil
+}